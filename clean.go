@@ -1,68 +1,222 @@
 package main
 
 import (
-    "bufio"
-    "fmt"
-    "os"
-    "sort"
-    "strings"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
 )
 
-func main() {
-    inputFileName := "names.csv" // Replace with your input file name
-
-    file, err := os.Open(inputFileName)
-    if err != nil {
-        fmt.Println("Error opening file:", err)
-        return
-    }
-    defer file.Close()
+// ExtractConfig controls one name extraction run. Columns picks how names
+// are built from a row (see newColumnStrategy), using FirstCol, MiddleCol,
+// LastCol and Template for the mapped strategy; Clean, Letters and MinLength
+// decide which of them are kept.
+type ExtractConfig struct {
+	// Inputs are files, glob patterns or directories; see expandInputs.
+	Inputs    []string
+	Columns   string
+	FirstCol  string
+	MiddleCol string
+	LastCol   string
+	Template  string
+	// MinConfidence and Confirm only apply to the header strategy.
+	MinConfidence float64
+	Confirm       bool
+	Clean         bool
+	Letters       bool
+	MinLength     int
+	// Dedup writes each name once to Output. Several inputs always do.
+	Dedup     bool
+	Output    string
+	AllOutput string
+	Workers   int
+	// MaxMemory caps the bytes of names held for sorting; past it sorted
+	// runs are spilled to TempDir and merged. With CountsOutput or Report,
+	// half of it goes to counting names instead, and a run with more
+	// distinct names than fit fails. Zero means no cap.
+	MaxMemory int64
+	TempDir   string
+	// RulesFile, when set, replaces Clean, Letters and MinLength with the
+	// rules it holds; RejectsOutput names a file listing every rejected
+	// value with its rule.
+	RulesFile     string
+	RejectsOutput string
+	// SourceFile adds the file each name was first found in to the outputs.
+	SourceFile bool
+	// CountsOutput and Report, when set, name files for per-name counts
+	// and a summary of the run ("-" prints the report); the report lists
+	// the TopNames most frequent names.
+	CountsOutput string
+	Report       string
+	TopNames     int
+}
 
-    namesMap := make(map[string]struct{})
-    scanner := bufio.NewScanner(file)
+// presets reproduce the extractors this tool replaced:
+//
+//	given-last         clean.go, Clean1.go, clean2.go, clean3.go
+//	given-last-strict  clean4.go
+//	header             clean5.go, clean6.go
+//	all-fields         clean7.go
+var presets = map[string]ExtractConfig{
+	"given-last": {
+		Columns: columnsFixed,
+		Dedup:   true,
+		Output:  "lookup.txt",
+	},
+	"given-last-strict": {
+		Columns:   columnsFixed,
+		Letters:   true,
+		MinLength: 3,
+		Dedup:     true,
+		Output:    "lookup.txt",
+	},
+	"header": {
+		Columns:   columnsHeader,
+		MinLength: 3,
+		Output:    "lookup.txt",
+	},
+	"all-fields": {
+		Columns:   columnsAll,
+		Clean:     true,
+		MinLength: 3,
+		Dedup:     true,
+		Output:    "unique_names.txt",
+		AllOutput: "all_names.txt",
+	},
+}
 
-    // Skip the header line
-    if scanner.Scan() {
-        // Read header
-    }
+func presetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
 
-    for scanner.Scan() {
-        line := scanner.Text()
-        columns := strings.Split(line, ",")
-        if len(columns) >= 5 {
-            givenNameOne := strings.TrimSpace(columns[0])
-            lastName := strings.TrimSpace(columns[4])
-            if givenNameOne != "" && lastName != "" {
-                fullName := strings.ToLower(givenNameOne + " " + lastName)
-                namesMap[fullName] = struct{}{}
-            }
-        }
-    }
+func main() {
+	preset := flag.String("preset", "given-last", "Starting configuration: "+strings.Join(presetNames(), ", "))
+	input := flag.String("input", "names.csv", "Input CSV file, glob pattern or directory; inputs given as arguments replace it")
+	columns := flag.String("columns", "", "Column strategy: fixed (columns 0 and 4), mapped (-first-col etc.), header (name-like header columns) or all (every field)")
+	firstCol := flag.String("first-col", "", "Given name column, by header name or zero-based index (implies -columns mapped)")
+	middleCol := flag.String("middle-col", "", "Optional middle name column, by header name or index")
+	lastCol := flag.String("last-col", "", "Last name column, by header name or index")
+	template := flag.String("template", "", `Name layout for mapped columns, e.g. "{last}, {first}" (default: mapped columns joined by spaces)`)
+	minConfidence := flag.Float64("min-confidence", 0.5, "Header strategy: minimum confidence for a column to count as a name column")
+	confirm := flag.Bool("confirm", false, "Header strategy: ask before using the detected columns")
+	clean := flag.Bool("clean", false, "Strip everything but letters and spaces from names")
+	letters := flag.Bool("letters", false, "Reject names containing anything but letters and spaces")
+	minLength := flag.Int("min-length", 0, "Reject names shorter than this many bytes")
+	dedup := flag.Bool("dedup", false, "Write each name once (always on with several inputs)")
+	output := flag.String("output", "", "Output file for the sorted names")
+	allOutput := flag.String("all-output", "", "Also write every name found, duplicates included, to this file")
+	maxMemory := flag.Int64("max-memory", 1024, "Megabytes of names to sort in memory before spilling sorted runs to disk, half of it for counting names with -counts or -report (0: no limit)")
+	tempDir := flag.String("temp-dir", "", "Directory for sort runs (default: system temp directory)")
+	rulesFile := flag.String("rules", "", "JSON file of validation rules; replaces -clean, -letters and -min-length")
+	rejects := flag.String("rejects", "", "Write every rejected value with the rule that rejected it to this CSV file")
+	sourceFile := flag.Bool("source-file", false, "Write name,source_file CSV records naming the first input each name was found in")
+	counts := flag.String("counts", "", "Write a CSV of name, count, first_row and source column to this file")
+	report := flag.String("report", "", `Write a summary report (top names, lengths, character sets, rejections) to this file, or "-" to print it`)
+	topNames := flag.Int("top", 20, "Number of most frequent names in the report")
+	workers := flag.Int("workers", runtime.NumCPU()*4, "Number of worker goroutines")
+	flag.Parse()
 
-    if err := scanner.Err(); err != nil {
-        fmt.Println("Error reading file:", err)
-        return
-    }
+	config, ok := presets[*preset]
+	if !ok {
+		fmt.Printf("Unknown preset %q (available: %s)\n", *preset, strings.Join(presetNames(), ", "))
+		os.Exit(2)
+	}
+	config.Inputs = flag.Args()
+	if len(config.Inputs) == 0 {
+		config.Inputs = []string{*input}
+	}
+	config.Workers = *workers
+	config.MinConfidence = *minConfidence
+	config.Confirm = *confirm
+	config.MaxMemory = *maxMemory * 1024 * 1024
+	config.TempDir = *tempDir
+	config.RulesFile = *rulesFile
+	config.RejectsOutput = *rejects
+	config.SourceFile = *sourceFile
+	config.CountsOutput = *counts
+	config.Report = *report
+	config.TopNames = *topNames
 
-    names := make([]string, 0, len(namesMap))
-    for name := range namesMap {
-        names = append(names, name)
-    }
+	// Flags given on the command line override the preset. Mapping a column
+	// switches to the mapped strategy unless -columns says otherwise.
+	columnsSet := false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "columns":
+			config.Columns = *columns
+			columnsSet = true
+		case "first-col":
+			config.FirstCol = *firstCol
+		case "middle-col":
+			config.MiddleCol = *middleCol
+		case "last-col":
+			config.LastCol = *lastCol
+		case "template":
+			config.Template = *template
+		case "clean":
+			config.Clean = *clean
+		case "letters":
+			config.Letters = *letters
+		case "min-length":
+			config.MinLength = *minLength
+		case "dedup":
+			config.Dedup = *dedup
+		case "output":
+			config.Output = *output
+		case "all-output":
+			config.AllOutput = *allOutput
+		}
+	})
+	if !columnsSet && config.FirstCol+config.MiddleCol+config.LastCol != "" {
+		config.Columns = columnsMapped
+	}
 
-    sort.Strings(names)
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	startTime := time.Now()
 
-    outputFile, err := os.Create("lookup.txt")
-    if err != nil {
-        fmt.Println("Error creating file:", err)
-        return
-    }
-    defer outputFile.Close()
+	stats, err := extractNames(config)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
 
-    writer := bufio.NewWriter(outputFile)
-    for _, name := range names {
-        writer.WriteString(name + "\n")
-    }
-    writer.Flush()
+	fmt.Printf("\nProcessing Summary:\n"+
+		"Total time: %s\n"+
+		"Input rows processed: %d\n"+
+		"Malformed rows skipped: %d\n"+
+		"Names found: %d\n"+
+		"Names written: %d\n"+
+		"Sort runs spilled to disk: %d\n"+
+		"Processing speed: %.2f rows/sec\n",
+		time.Since(startTime),
+		stats.Rows,
+		stats.MalformedRows,
+		stats.NamesFound,
+		stats.NamesWritten,
+		stats.SortRuns,
+		float64(stats.Rows)/time.Since(startTime).Seconds())
+	fmt.Printf("Names written to %s successfully.\n", config.Output)
 
-    fmt.Println("Names written to lookup.txt successfully.")
+	failed := 0
+	for _, file := range stats.Files {
+		if file.Err != nil {
+			failed++
+		}
+	}
+	if len(stats.Files) > 1 || failed > 0 {
+		fmt.Println("\nInput files:")
+		writeFileStats(os.Stdout, stats.Files)
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d input files failed.\n", failed, len(stats.Files))
+		os.Exit(1)
+	}
 }
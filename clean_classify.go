@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	roleFirst  = "first"
+	roleMiddle = "middle"
+	roleLast   = "last"
+	roleFull   = "full"
+
+	classifySampleRows = 200
+)
+
+// headerSynonyms maps normalized header text to the part of a name it holds.
+// An exact match scores highest; a header merely containing one of these
+// phrases, like "customer first name", scores lower.
+var headerSynonyms = map[string]string{
+	"first name":     roleFirst,
+	"firstname":      roleFirst,
+	"first":          roleFirst,
+	"given name":     roleFirst,
+	"givenname":      roleFirst,
+	"given name one": roleFirst,
+	"given":          roleFirst,
+	"forename":       roleFirst,
+	"fname":          roleFirst,
+	"middle name":    roleMiddle,
+	"middlename":     roleMiddle,
+	"middle":         roleMiddle,
+	"middle initial": roleMiddle,
+	"mname":          roleMiddle,
+	"last name":      roleLast,
+	"lastname":       roleLast,
+	"last":           roleLast,
+	"surname":        roleLast,
+	"family name":    roleLast,
+	"familyname":     roleLast,
+	"lname":          roleLast,
+	"name":           roleFull,
+	"full name":      roleFull,
+	"fullname":       roleFull,
+	"person name":    roleFull,
+	"contact name":   roleFull,
+	"customer name":  roleFull,
+}
+
+// headerExclusions are words that mark a header as something other than a
+// personal name even when it also says "name", "first" or "last".
+var headerExclusions = []string{
+	"company", "business", "organisation", "organization", "org", "employer",
+	"account", "brand", "product", "file", "user", "username", "login",
+	"logon", "date", "time", "purchase", "order", "email", "mail", "street",
+	"city", "country", "domain", "host", "id", "code", "number", "seen",
+	"visit", "updated", "modified", "created",
+}
+
+var (
+	camelCaseBoundary = regexp.MustCompile(`([a-z])([A-Z])`)
+	headerSeparators  = regexp.MustCompile(`[\s_\-.]+`)
+	corporateSuffix   = regexp.MustCompile(`\b(inc|ltd|llc|llp|corp|co|gmbh|plc|company|group|bank)\.?$`)
+)
+
+// normalizeHeader lower-cases a header and splits it into space-separated
+// words, so "Last_Name", "last-name" and "lastName" all read "last name".
+func normalizeHeader(header string) string {
+	header = camelCaseBoundary.ReplaceAllString(header, "$1 $2")
+	return strings.TrimSpace(headerSeparators.ReplaceAllString(strings.ToLower(header), " "))
+}
+
+// classifyHeader guesses which part of a name a header holds from its text
+// alone, with a score between 0 and 1 and a short reason.
+func classifyHeader(header string) (role string, score float64, reason string) {
+	normalized := normalizeHeader(header)
+	if normalized == "" {
+		return "", 0, "empty header"
+	}
+
+	words := strings.Fields(normalized)
+	for _, word := range words {
+		for _, excluded := range headerExclusions {
+			if word == excluded {
+				if role, _, _ = classifyHeader(strings.Join(removeWord(words, word), " ")); role == "" {
+					role = "-"
+				}
+				return role, 0, "excluded: " + word
+			}
+		}
+	}
+
+	if role, ok := headerSynonyms[normalized]; ok {
+		return role, 1, "synonym"
+	}
+
+	// Longest phrase first, so "first name" wins over "name"; phrases of
+	// the same length, like "last" and "name", are settled alphabetically
+	// so the answer does not depend on map order.
+	best := ""
+	padded := " " + normalized + " "
+	for phrase := range headerSynonyms {
+		if !strings.Contains(padded, " "+phrase+" ") {
+			continue
+		}
+		if len(phrase) > len(best) || (len(phrase) == len(best) && phrase < best) {
+			best = phrase
+		}
+	}
+	if best != "" {
+		return headerSynonyms[best], 0.7, "contains synonym"
+	}
+	return "", 0, "no name synonym"
+}
+
+func removeWord(words []string, word string) []string {
+	kept := make([]string, 0, len(words))
+	for _, w := range words {
+		if w != word {
+			kept = append(kept, w)
+		}
+	}
+	return kept
+}
+
+// looksLikePersonName is a rough check that a value could be (part of) a
+// personal name: a few words of letters and name punctuation, no digits and
+// no company suffix.
+func looksLikePersonName(value string) bool {
+	value = strings.TrimSpace(value)
+	if value == "" || len(value) > 60 {
+		return false
+	}
+	if len(strings.Fields(value)) > 4 {
+		return false
+	}
+	for _, r := range value {
+		if !unicode.IsLetter(r) && !unicode.IsSpace(r) && !strings.ContainsRune("'-.,", r) {
+			return false
+		}
+	}
+	return !corporateSuffix.MatchString(strings.ToLower(value))
+}
+
+// sampleScore is the share of non-empty sampled values in column that look
+// like personal names. It returns -1 when the sample has no values for it.
+func sampleScore(sample [][]string, column int) float64 {
+	var values, names int
+	for _, row := range sample {
+		if column >= len(row) || strings.TrimSpace(row[column]) == "" {
+			continue
+		}
+		values++
+		if looksLikePersonName(row[column]) {
+			names++
+		}
+	}
+	if values == 0 {
+		return -1
+	}
+	return float64(names) / float64(values)
+}
+
+// classifyColumns scores every column as a possible name part. Confidence is
+// the header score scaled by how name-like the sampled values are; without
+// sampled values the header score stands alone.
+func classifyColumns(headers []string, sample [][]string, minConfidence float64) []ColumnInfo {
+	columns := make([]ColumnInfo, 0, len(headers))
+	for idx, header := range headers {
+		role, score, reason := classifyHeader(header)
+		confidence := score
+		if data := sampleScore(sample, idx); data >= 0 && score > 0 {
+			confidence = score * (0.25 + 0.75*data)
+			reason = fmt.Sprintf("%s, %.0f%% of sampled values look like names", reason, data*100)
+		}
+
+		columns = append(columns, ColumnInfo{
+			index:        idx,
+			name:         strings.TrimSpace(header),
+			role:         role,
+			confidence:   confidence,
+			reason:       reason,
+			isNameColumn: role != "" && role != "-" && confidence > 0 && confidence >= minConfidence,
+		})
+	}
+	return columns
+}
+
+// selectNameColumns picks the most confident column for each name part.
+// First, middle and last names are used when there is a first or last name
+// column; otherwise a full name column is.
+func selectNameColumns(columns []ColumnInfo) []ColumnInfo {
+	best := make(map[string]ColumnInfo)
+	for _, col := range columns {
+		if !col.isNameColumn {
+			continue
+		}
+		if current, ok := best[col.role]; !ok || col.confidence > current.confidence {
+			best[col.role] = col
+		}
+	}
+
+	var selected []ColumnInfo
+	for _, role := range []string{roleFirst, roleMiddle, roleLast} {
+		if col, ok := best[role]; ok {
+			selected = append(selected, col)
+		}
+	}
+	_, hasFirst := best[roleFirst]
+	_, hasLast := best[roleLast]
+	if !hasFirst && !hasLast {
+		selected = nil
+		if col, ok := best[roleFull]; ok {
+			selected = append(selected, col)
+		}
+	}
+	return selected
+}
+
+// printColumnMapping shows how every column was classified, which ones will
+// be used, and the flags that reproduce or override the choice.
+func printColumnMapping(w io.Writer, columns, selected []ColumnInfo, minConfidence float64) {
+	used := make(map[int]bool)
+	for _, col := range selected {
+		used[col.index] = true
+	}
+
+	fmt.Fprintf(w, "Detected columns (minimum confidence %.2f):\n", minConfidence)
+	for _, col := range columns {
+		role := col.role
+		if role == "" {
+			role = "-"
+		}
+		marker := ""
+		if used[col.index] {
+			marker = "  <- used"
+		}
+		fmt.Fprintf(w, "- %s (index: %d, role: %s, confidence: %.2f; %s)%s\n",
+			col.name, col.index, role, col.confidence, col.reason, marker)
+	}
+
+	var flags []string
+	for _, col := range selected {
+		flagName := col.role
+		if flagName == roleFull {
+			flagName = roleFirst
+		}
+		flags = append(flags, fmt.Sprintf("-%s-col %q", flagName, col.name))
+	}
+	if len(flags) == 0 {
+		fmt.Fprintln(w, "No name columns detected; map them with -first-col, -middle-col and -last-col.")
+		return
+	}
+	fmt.Fprintf(w, "To override, map columns explicitly, e.g.: %s\n", strings.Join(flags, " "))
+}
+
+// confirmInput is where confirmMapping reads answers. It is shared by every
+// prompt of a run, so with several inputs and piped answers each prompt
+// reads its own line instead of the first one buffering them all.
+var confirmInput = bufio.NewReader(os.Stdin)
+
+// confirmMapping asks on in whether to go ahead with the detected columns.
+// An empty line counts as yes; running out of input, or anything but a yes,
+// counts as no.
+func confirmMapping(in *bufio.Reader, out io.Writer) bool {
+	fmt.Fprint(out, "Use this mapping? [Y/n] ")
+	answer, err := in.ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Fprintln(out)
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "" || answer == "y" || answer == "yes"
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestClassifyHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		role   string
+		score  float64
+	}{
+		{"First Name", roleFirst, 1},
+		{"last_name", roleLast, 1},
+		{"middleName", roleMiddle, 1},
+		{"Customer First Name", roleFirst, 0.7},
+		{"Company Name", roleFull, 0},
+		{"Last Login", roleLast, 0},
+		{"First Purchase Date", roleFirst, 0},
+		{"Email", "-", 0},
+		// "last" and "name" are the same length; the alphabetically first
+		// wins, whatever order the synonyms are visited in.
+		{"Name Last", roleLast, 0.7},
+		{"Given First", roleFirst, 0.7},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			role, score, reason := classifyHeader(tt.header)
+			if role != tt.role || score != tt.score {
+				t.Fatalf("classifyHeader(%q) = %q, %.2f (%s); want %q, %.2f", tt.header, role, score, reason, tt.role, tt.score)
+			}
+		}
+	}
+}
+
+// Each prompt of a run reads its own line from the shared reader.
+func TestConfirmMappingSharedInput(t *testing.T) {
+	in := bufio.NewReader(strings.NewReader("n\n\nyes\nNo\n"))
+	want := []bool{false, true, true, false, false}
+	for i, w := range want {
+		if got := confirmMapping(in, io.Discard); got != w {
+			t.Errorf("prompt %d: got %v, want %v", i+1, got, w)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	columnsFixed  = "fixed"
+	columnsMapped = "mapped"
+	columnsHeader = "header"
+	columnsAll    = "all"
+)
+
+// rawName is a name as a columnStrategy found it, with the column or
+// columns it was taken from.
+type rawName struct {
+	value  string
+	source string
+}
+
+// columnStrategy turns one CSV row into the raw names it contains.
+type columnStrategy func(fields []string) []rawName
+
+// newColumnStrategy builds the strategy config asks for. The header strategy
+// also looks at sample, the first rows of the input.
+func newColumnStrategy(config ExtractConfig, header []string, sample [][]string) (columnStrategy, error) {
+	switch config.Columns {
+	case columnsFixed:
+		// The given name is in the first column and the last name in the
+		// fifth.
+		return mappedColumns("0", "", "4", "", header)
+	case columnsMapped:
+		return mappedColumns(config.FirstCol, config.MiddleCol, config.LastCol, config.Template, header)
+	case columnsHeader:
+		columns := getColumnIndices(header, sample, config.MinConfidence)
+		selected := selectNameColumns(columns)
+		printColumnMapping(os.Stdout, columns, selected, config.MinConfidence)
+		if len(selected) == 0 {
+			return nil, errors.New("no name columns detected")
+		}
+		if config.Confirm && !confirmMapping(confirmInput, os.Stdout) {
+			return nil, errors.New("column mapping rejected; map columns with -first-col, -middle-col and -last-col")
+		}
+		return headerColumns(len(header), selected), nil
+	case columnsAll:
+		return allColumns(header), nil
+	default:
+		return nil, fmt.Errorf("unknown column strategy %q", config.Columns)
+	}
+}
+
+var templatePlaceholder = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// templatePart is either literal text or, when isValue is set, the trimmed
+// value of column.
+type templatePart struct {
+	literal string
+	column  int
+	isValue bool
+}
+
+// mappedColumns builds names from explicitly chosen columns. Each column is
+// given by header name or zero-based index; empty means unmapped. template
+// places the values, e.g. "{last}, {first}"; by default the mapped columns
+// are joined with spaces in first, middle, last order. A row yields nothing
+// if its first or last name is empty; the middle name is optional.
+func mappedColumns(first, middle, last, template string, header []string) (columnStrategy, error) {
+	specs := map[string]string{"first": first, "middle": middle, "last": last}
+	indices := make(map[string]int)
+	for _, placeholder := range []string{"first", "middle", "last"} {
+		if specs[placeholder] == "" {
+			continue
+		}
+		index, err := resolveColumn(specs[placeholder], header)
+		if err != nil {
+			return nil, fmt.Errorf("-%s-col: %w", placeholder, err)
+		}
+		indices[placeholder] = index
+	}
+	if len(indices) == 0 {
+		return nil, fmt.Errorf("mapped columns need at least one of -first-col, -middle-col or -last-col")
+	}
+
+	if template == "" {
+		var placeholders []string
+		for _, placeholder := range []string{"first", "middle", "last"} {
+			if _, ok := indices[placeholder]; ok {
+				placeholders = append(placeholders, "{"+placeholder+"}")
+			}
+		}
+		template = strings.Join(placeholders, " ")
+	}
+
+	var parts []templatePart
+	var columns []string
+	var required []int
+	maxIndex := 0
+	rest := template
+	for rest != "" {
+		loc := templatePlaceholder.FindStringSubmatchIndex(rest)
+		if loc == nil {
+			parts = append(parts, templatePart{literal: rest})
+			break
+		}
+		if loc[0] > 0 {
+			parts = append(parts, templatePart{literal: rest[:loc[0]]})
+		}
+		placeholder := rest[loc[2]:loc[3]]
+		index, ok := indices[placeholder]
+		if !ok {
+			if _, known := specs[placeholder]; known {
+				return nil, fmt.Errorf("template uses {%s} but -%s-col is not set", placeholder, placeholder)
+			}
+			return nil, fmt.Errorf("unknown template placeholder {%s}", placeholder)
+		}
+		parts = append(parts, templatePart{column: index, isValue: true})
+		columns = append(columns, columnLabel(header, index))
+		if placeholder != "middle" {
+			required = append(required, index)
+		}
+		maxIndex = max(maxIndex, index)
+		rest = rest[loc[1]:]
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("template %q uses none of {first}, {middle} or {last}", template)
+	}
+
+	source := strings.Join(columns, "+")
+	return func(fields []string) []rawName {
+		if len(fields) <= maxIndex {
+			return nil
+		}
+		for _, index := range required {
+			if strings.TrimSpace(fields[index]) == "" {
+				return nil
+			}
+		}
+
+		var name strings.Builder
+		for _, part := range parts {
+			text := part.literal
+			if part.isValue {
+				text = strings.TrimSpace(fields[part.column])
+			}
+			// Keeps an empty middle name from leaving a double space.
+			if strings.HasSuffix(name.String(), " ") {
+				text = strings.TrimLeft(text, " ")
+			}
+			name.WriteString(text)
+		}
+		return []rawName{{value: strings.TrimSpace(name.String()), source: source}}
+	}, nil
+}
+
+// resolveColumn finds a column by zero-based index or, failing that, by
+// case-insensitive header name.
+func resolveColumn(spec string, header []string) (int, error) {
+	if index, err := strconv.Atoi(spec); err == nil {
+		if index < 0 {
+			return 0, fmt.Errorf("column index %d is negative", index)
+		}
+		return index, nil
+	}
+	for i, column := range header {
+		if strings.EqualFold(strings.TrimSpace(column), strings.TrimSpace(spec)) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no column named %q in header", spec)
+}
+
+// columnLabel names a column for reports: its header, or its index when the
+// header has none.
+func columnLabel(header []string, index int) string {
+	if index < len(header) && strings.TrimSpace(header[index]) != "" {
+		return strings.TrimSpace(header[index])
+	}
+	return "column " + strconv.Itoa(index)
+}
+
+// allColumns takes every field as a name of its own. Blank fields are no
+// name at all, so they are skipped rather than rejected as empty.
+func allColumns(header []string) columnStrategy {
+	return func(fields []string) []rawName {
+		names := make([]rawName, 0, len(fields))
+		for i, field := range fields {
+			if strings.TrimSpace(field) == "" {
+				continue
+			}
+			names = append(names, rawName{value: field, source: columnLabel(header, i)})
+		}
+		return names
+	}
+}
+
+// ColumnInfo is how the header strategy classified one column. role is the
+// part of a name the column seems to hold, if any.
+type ColumnInfo struct {
+	index        int
+	name         string
+	role         string
+	confidence   float64
+	reason       string
+	isNameColumn bool
+}
+
+// getColumnIndices classifies every column from its header and sampled
+// values; see classifyColumns.
+func getColumnIndices(headers []string, sample [][]string, minConfidence float64) []ColumnInfo {
+	return classifyColumns(headers, sample, minConfidence)
+}
+
+// headerColumns joins the non-empty values of the selected columns, in
+// first, middle, last order. Rows shorter than the header are skipped.
+func headerColumns(headerLen int, selected []ColumnInfo) columnStrategy {
+	columns := make([]string, len(selected))
+	for i, col := range selected {
+		columns[i] = col.name
+	}
+	source := strings.Join(columns, "+")
+
+	return func(fields []string) []rawName {
+		if len(fields) < headerLen {
+			return nil
+		}
+
+		nameComponents := make([]string, 0, len(selected))
+		for _, col := range selected {
+			value := strings.TrimSpace(fields[col.index])
+			if value != "" {
+				nameComponents = append(nameComponents, value)
+			}
+		}
+		if len(nameComponents) == 0 {
+			return nil
+		}
+		return []rawName{{value: strings.Join(nameComponents, " "), source: source}}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMappedColumnsTemplate(t *testing.T) {
+	header := []string{"First", "Middle", "Last"}
+	tests := []struct {
+		template string
+		row      []string
+		want     []rawName
+	}{
+		{"", []string{"Ann", "", "Lee"}, []rawName{{"Ann Lee", "First+Middle+Last"}}},
+		{"{last}, {first}", []string{"Ann", "", "Lee"}, []rawName{{"Lee, Ann", "Last+First"}}},
+		{"{first} {middle} {last}", []string{"Ann", "", "Lee"}, []rawName{{"Ann Lee", "First+Middle+Last"}}},
+		{"{first} {middle} {last}", []string{"Ann", "B", "Lee"}, []rawName{{"Ann B Lee", "First+Middle+Last"}}},
+		{"{first} {last}", []string{"Ann", "", " "}, nil},
+	}
+	for _, tt := range tests {
+		strategy, err := mappedColumns("First", "Middle", "Last", tt.template, header)
+		if err != nil {
+			t.Fatalf("template %q: %v", tt.template, err)
+		}
+		if got := strategy(tt.row); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("template %q, row %q: got %v, want %v", tt.template, tt.row, got, tt.want)
+		}
+	}
+}
+
+func TestMappedColumnsTemplateErrors(t *testing.T) {
+	header := []string{"First", "Middle", "Last"}
+	tests := []struct {
+		first, last, template string
+		want                  string
+	}{
+		{"First", "Last", "x", "uses none of"},
+		{"First", "Last", "Mr. Smith", "uses none of"},
+		{"First", "Last", "{nick}", "unknown template placeholder"},
+		{"First", "", "{first} {last}", "-last-col is not set"},
+		{"", "", "{first}", "at least one of"},
+		{"Nope", "Last", "", "Nope"},
+	}
+	for _, tt := range tests {
+		_, err := mappedColumns(tt.first, "", tt.last, tt.template, header)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("first %q, last %q, template %q: got error %v, want one containing %q", tt.first, tt.last, tt.template, err, tt.want)
+		}
+	}
+}
+
+func TestAllColumnsSkipsBlankFields(t *testing.T) {
+	strategy := allColumns([]string{"A", "", "C", "D"})
+	got := strategy([]string{"ann", "", "  ", "bob smith", "extra"})
+	want := []rawName{
+		{value: "ann", source: "A"},
+		{value: "bob smith", source: "D"},
+		{value: "extra", source: "column 4"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ioBufferSize    = 10 * 1024 * 1024
+	rangeBufferSize = 1024 * 1024
+
+	// sortBatchSize is how many names a worker gathers before handing them
+	// to the sorter.
+	sortBatchSize = 4096
+)
+
+// ExtractStats totals an extraction run; Files breaks the rows down by
+// input.
+type ExtractStats struct {
+	Rows          int64
+	MalformedRows int64
+	NamesFound    int64
+	NamesWritten  int64
+	SortRuns      int
+	Files         []FileStats
+}
+
+// FileStats is what extraction made of one input file. Err is set when the
+// file could not be found or opened, in which case nothing was read from
+// it, or when one of its ranges failed part way. Ranges are parsed in
+// parallel, so a failed range stops only itself: the file's other ranges,
+// including ones after the failure, are still read and their names
+// written. Err is the error of the first range that failed, in file order.
+type FileStats struct {
+	Path string
+	// Rows and MalformedRows count every row read from the file, summed
+	// over its ranges; a failed range counts the rows it read before it
+	// failed.
+	Rows          int64
+	MalformedRows int64
+	Err           error
+}
+
+// input is one file to extract from, ready to be parsed.
+type input struct {
+	path        string
+	compression string
+	strategy    columnStrategy
+	ranges      []byteRange
+}
+
+// extraction is what every range of a run shares.
+type extraction struct {
+	config   ExtractConfig
+	codec    entryCodec
+	pipeline *namePipeline
+	rejects  *rejectLog // nil unless -rejects is set
+	progress func()
+}
+
+// inputRange is one piece of work: a byte range of a plain input, or the
+// whole of a compressed one, which can only be read from the start.
+type inputRange struct {
+	input  int
+	stream bool
+	byteRange
+}
+
+// extractNames reads config.Inputs, collects the names every row yields and
+// writes them sorted to config.Output. Past config.MaxMemory bytes of names
+// the sort spills to disk; see nameSorter. An input that cannot be read is
+// reported in its FileStats and the others carry on; only when none can be
+// read does extractNames fail.
+func extractNames(config ExtractConfig) (*ExtractStats, error) {
+	files := expandInputs(config.Inputs)
+	paths := make([]string, len(files))
+	for i, file := range files {
+		paths[i] = file.Path
+	}
+	// Several inputs make one lookup list, so each name goes in once
+	// whatever the preset does for a single file.
+	if len(paths) > 1 {
+		config.Dedup = true
+	}
+
+	rules := rulesFromConfig(config)
+	if config.RulesFile != "" {
+		var err error
+		if rules, err = loadRules(config.RulesFile); err != nil {
+			return nil, err
+		}
+	}
+	pipeline, err := newNamePipeline(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	workers := config.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	stats := &ExtractStats{Files: files}
+	inputs := make([]*input, len(paths))
+	var work []inputRange
+	for i, path := range paths {
+		if files[i].Err != nil {
+			fmt.Printf("Skipping %s: %v\n", path, files[i].Err)
+			continue
+		}
+		if len(paths) > 1 && config.Columns == columnsHeader {
+			fmt.Printf("\n%s:\n", path)
+		}
+		in, err := prepareInput(path, config, workers)
+		if err != nil {
+			stats.Files[i].Err = err
+			fmt.Printf("Skipping %s: %v\n", path, err)
+			continue
+		}
+		inputs[i] = in
+		if in.compression != "none" {
+			work = append(work, inputRange{input: i, stream: true})
+		}
+		for _, r := range in.ranges {
+			work = append(work, inputRange{input: i, byteRange: r})
+		}
+	}
+	if err := allFailed(stats.Files); err != nil {
+		return nil, err
+	}
+
+	var codec entryCodec
+	if config.SourceFile {
+		codec.files = paths
+	}
+	// Counting names takes half of -max-memory from the sorter, split
+	// between the workers like the sorter's share.
+	sortConfig := config
+	var tallies []*nameTally
+	if config.CountsOutput != "" || config.Report != "" {
+		var tallyBytes int64
+		if config.MaxMemory > 0 {
+			tallyBytes = max(config.MaxMemory/2/int64(workers), 1)
+			sortConfig.MaxMemory -= config.MaxMemory / 2
+		}
+		tallies = make([]*nameTally, workers)
+		for i := range tallies {
+			tallies[i] = newNameTally(tallyBytes)
+		}
+	}
+	sorter := newNameSorter(sortConfig, codec, workers)
+	defer sorter.Close()
+	rangeStats := make([]ExtractStats, len(work))
+	rangeErrs := make([]error, len(work))
+	var rows atomic.Int64
+	scanStart := time.Now()
+	x := &extraction{
+		config:   config,
+		codec:    codec,
+		pipeline: pipeline,
+		progress: func() {
+			if n := rows.Add(1); n%1000000 == 0 {
+				fmt.Printf("Processed %d million rows (Speed: %.2f rows/sec)\n",
+					n/1000000, float64(n)/time.Since(scanStart).Seconds())
+			}
+		},
+	}
+	if config.RejectsOutput != "" {
+		x.rejects = newRejectLog(config.TempDir, len(work))
+		defer x.rejects.Close()
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		var tally *nameTally
+		if tallies != nil {
+			tally = tallies[i]
+		}
+		go func(shard *sortShard, tally *nameTally) {
+			defer wg.Done()
+			for r := range next {
+				item := work[r]
+				rangeErrs[r] = x.extractRange(inputs[item.input], item, r, shard, tally, &rangeStats[r])
+			}
+		}(sorter.shard(i), tally)
+	}
+	for r := range work {
+		next <- r
+	}
+	close(next)
+	wg.Wait()
+	for _, tally := range tallies {
+		if tally.err != nil {
+			return nil, tally.err
+		}
+	}
+
+	// Totals are summed in input order, whichever worker parsed a range.
+	// rowOffsets number each range's rows on from the ranges before it in
+	// the same file.
+	rowOffsets := make([]int64, len(work))
+	for r, item := range work {
+		file := &stats.Files[item.input]
+		rowOffsets[r] = file.Rows + file.MalformedRows
+		if rangeErrs[r] != nil && file.Err == nil {
+			file.Err = rangeErrs[r]
+		}
+		file.Rows += rangeStats[r].Rows
+		file.MalformedRows += rangeStats[r].MalformedRows
+	}
+	if err := allFailed(stats.Files); err != nil {
+		return nil, err
+	}
+	for _, file := range stats.Files {
+		stats.Rows += file.Rows
+		stats.MalformedRows += file.MalformedRows
+	}
+
+	written, err := sorter.finish(config.Output, config.AllOutput, config.Dedup)
+	if err != nil {
+		return nil, err
+	}
+	stats.NamesFound = sorter.found()
+	stats.NamesWritten = written
+	stats.SortRuns = len(sorter.runs())
+
+	rangeFiles := make([]string, len(work))
+	for r, item := range work {
+		rangeFiles[r] = paths[item.input]
+	}
+	if tallies != nil {
+		if err := writeTallies(config, stats, pipeline, tallies, rowOffsets, rangeFiles); err != nil {
+			return nil, err
+		}
+	}
+	if x.rejects != nil {
+		if err := x.rejects.finish(config.RejectsOutput, rowOffsets, rangeFiles); err != nil {
+			return nil, err
+		}
+	}
+
+	return stats, nil
+}
+
+// allFailed returns the files' errors joined when every one of them failed,
+// so that no output is written from nothing.
+func allFailed(files []FileStats) error {
+	var errs []error
+	for _, file := range files {
+		if file.Err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", file.Path, file.Err))
+	}
+	return errors.Join(errs...)
+}
+
+// prepareInput reads the header and first rows of path to pick its column
+// strategy, and splits it into ranges unless it is compressed.
+func prepareInput(path string, config ExtractConfig, workers int) (*input, error) {
+	compression, err := inputCompression(path)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := openInput(path, compression)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	reader := csv.NewReader(bufio.NewReaderSize(stream, ioBufferSize))
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	dataStart := reader.InputOffset()
+
+	// The header strategy looks at the first rows to judge which columns
+	// hold names; the ranges parse them again like any other row.
+	var sample [][]string
+	for len(sample) < classifySampleRows {
+		fields, err := readRow(reader, &ExtractStats{})
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		sample = append(sample, fields)
+	}
+
+	strategy, err := newColumnStrategy(config, header, sample)
+	if err != nil {
+		return nil, err
+	}
+	in := &input{path: path, compression: compression, strategy: strategy}
+	if compression != "none" {
+		return in, nil
+	}
+
+	// Workers parse whole ranges of the file, each starting on a record
+	// boundary, so no single reader has to see every row.
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if in.ranges, err = splitRanges(file, dataStart, info.Size(), workers); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// extractRange parses one piece of in, work item index, and hands the names
+// it yields to shard and, when set, tally.
+func (x *extraction) extractRange(in *input, item inputRange, index int,
+	shard *sortShard, tally *nameTally, stats *ExtractStats) (err error) {
+	var source io.Reader
+	if item.stream {
+		stream, err := openInput(in.path, in.compression)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		source = stream
+	} else {
+		file, err := os.Open(in.path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		source = io.NewSectionReader(file, item.start, item.end-item.start)
+	}
+
+	if x.rejects != nil {
+		defer func() {
+			if closeErr := x.rejects.closeRange(index); err == nil {
+				err = closeErr
+			}
+		}()
+	}
+
+	reader := csv.NewReader(bufio.NewReaderSize(source, rangeBufferSize))
+	reader.FieldsPerRecord = -1
+	if item.stream {
+		if _, err := reader.Read(); err != nil {
+			return fmt.Errorf("reading header: %w", err)
+		}
+	}
+
+	localNames := make([]string, 0, sortBatchSize)
+	for {
+		fields, err := readRow(reader, stats)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			shard.add(localNames)
+			return err
+		}
+		stats.Rows++
+		x.progress()
+		row := stats.Rows + stats.MalformedRows
+
+		for _, raw := range in.strategy(fields) {
+			name, rejectedBy, detail := x.pipeline.apply(raw.value)
+			if tally != nil {
+				if x.pipeline.clean && name != strings.ToLower(strings.TrimSpace(raw.value)) {
+					tally.cleaned++
+				}
+				if rejectedBy != "" {
+					tally.rejected[rejectedBy]++
+				} else {
+					tally.add(name, raw.source, index, row)
+				}
+			}
+			if rejectedBy == "" {
+				localNames = append(localNames, x.codec.encode(name, item.input))
+			} else if x.rejects != nil {
+				if err := x.rejects.record(index, row, raw, name, rejectedBy, detail); err != nil {
+					shard.add(localNames)
+					return err
+				}
+			}
+		}
+		if len(localNames) >= sortBatchSize {
+			shard.add(localNames)
+			localNames = localNames[:0]
+		}
+	}
+	shard.add(localNames)
+	return nil
+}
+
+// writeTallies merges the workers' tallies and writes the -counts and
+// -report outputs.
+func writeTallies(config ExtractConfig, stats *ExtractStats, pipeline *namePipeline, tallies []*nameTally, rowOffsets []int64, rangeFiles []string) error {
+	tally := tallies[0]
+	for _, other := range tallies[1:] {
+		tally.merge(other)
+	}
+	list := tally.frequencies(rowOffsets, rangeFiles)
+
+	if config.CountsOutput != "" {
+		if err := writeCounts(config.CountsOutput, list, config.SourceFile); err != nil {
+			return err
+		}
+	}
+	if config.Report == "" {
+		return nil
+	}
+	if config.Report == "-" {
+		fmt.Println()
+		return writeReport(os.Stdout, config, stats, pipeline, tally, list, config.TopNames)
+	}
+	file, err := os.Create(config.Report)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := writeReport(file, config, stats, pipeline, tally, list, config.TopNames); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// readRow returns the next well-formed row, counting and skipping rows the
+// CSV reader cannot parse.
+func readRow(reader *csv.Reader, stats *ExtractStats) ([]string, error) {
+	for {
+		fields, err := reader.Read()
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			stats.MalformedRows++
+			continue
+		}
+		return fields, err
+	}
+}
+
+// uniqueSorted drops repeats from a sorted slice in place.
+func uniqueSorted(names []string) []string {
+	unique := names[:0]
+	for _, name := range names {
+		if len(unique) == 0 || name != unique[len(unique)-1] {
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// inputExtensions are the files a directory given as input contributes.
+var inputExtensions = []string{".csv", ".csv.gz", ".csv.bz2"}
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+)
+
+// expandInputs turns paths, glob patterns and directories into the files to
+// read, in the order given and without repeats. Directories are searched
+// recursively for inputExtensions. A path naming an existing file is read
+// as it is, even when it looks like a pattern. An input that is malformed,
+// matches nothing or cannot be searched is listed with its Err set, so it
+// is reported with the rest instead of stopping the run.
+func expandInputs(patterns []string) []FileStats {
+	var files []FileStats
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, FileStats{Path: path})
+		}
+	}
+	fail := func(path string, err error) {
+		files = append(files, FileStats{Path: path, Err: err})
+	}
+
+	for _, pattern := range patterns {
+		matches := []string{pattern}
+		if _, err := os.Lstat(pattern); err != nil {
+			if matches, err = filepath.Glob(pattern); err != nil {
+				fail(pattern, err)
+				continue
+			}
+			if len(matches) == 0 {
+				fail(pattern, errors.New("no such file"))
+				continue
+			}
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				fail(match, err)
+				continue
+			}
+			if !info.IsDir() {
+				add(match)
+				continue
+			}
+			filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					fail(path, err)
+					return nil
+				}
+				if d.Type().IsRegular() && hasInputExtension(path) {
+					add(path)
+				}
+				return nil
+			})
+		}
+	}
+	return files
+}
+
+func hasInputExtension(path string) bool {
+	lower := strings.ToLower(path)
+	for _, ext := range inputExtensions {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// inputCompression sniffs how path is compressed: "gzip", "bzip2" or
+// "none".
+func inputCompression(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, 3)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	switch head = head[:n]; {
+	case bytes.HasPrefix(head, gzipMagic):
+		return "gzip", nil
+	case bytes.HasPrefix(head, bzip2Magic):
+		return "bzip2", nil
+	default:
+		return "none", nil
+	}
+}
+
+// decompressedFile reads a compressed input and closes the file under it.
+type decompressedFile struct {
+	io.Reader
+	file *os.File
+}
+
+func (d *decompressedFile) Close() error {
+	if closer, ok := d.Reader.(io.Closer); ok {
+		closer.Close()
+	}
+	return d.file.Close()
+}
+
+// openInput opens path for reading, decompressing it as compression says.
+func openInput(path, compression string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch compression {
+	case "none":
+		return file, nil
+	case "gzip":
+		gz, err := gzip.NewReader(bufio.NewReaderSize(file, rangeBufferSize))
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("reading gzip header: %w", err)
+		}
+		return &decompressedFile{Reader: gz, file: file}, nil
+	case "bzip2":
+		return &decompressedFile{Reader: bzip2.NewReader(bufio.NewReaderSize(file, rangeBufferSize)), file: file}, nil
+	default:
+		file.Close()
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestExpandInputsRecordsFailures checks that inputs which cannot be
+// expanded are listed as failed files in order, and that a file whose name
+// looks like a pattern is read as it is.
+func TestExpandInputsRecordsFailures(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.csv", "b.csv", "names[1].csv", filepath.Join("sub", "c.csv.gz")} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	inputs := []string{
+		filepath.Join(dir, "missing.csv"),
+		filepath.Join(dir, "names[1].csv"),
+		filepath.Join(dir, "[.csv"),
+		filepath.Join(dir, "*.csv"),
+		filepath.Join(dir, "sub"),
+	}
+	var got []string
+	var failed []bool
+	for _, file := range expandInputs(inputs) {
+		got = append(got, file.Path)
+		failed = append(failed, file.Err != nil)
+	}
+
+	want := []string{
+		filepath.Join(dir, "missing.csv"),
+		filepath.Join(dir, "names[1].csv"),
+		filepath.Join(dir, "[.csv"),
+		filepath.Join(dir, "a.csv"),
+		filepath.Join(dir, "b.csv"),
+		filepath.Join(dir, "sub", "c.csv.gz"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q\nwant %q", got, want)
+	}
+	if wantFailed := []bool{true, false, true, false, false, false}; !reflect.DeepEqual(failed, wantFailed) {
+		t.Fatalf("failed %v, want %v", failed, wantFailed)
+	}
+}
+
+// TestMultipleInputs runs the header preset, which keeps repeats for one
+// file, over two files and a missing one: the merged output holds each
+// name once and the missing file is reported, not fatal.
+func TestMultipleInputs(t *testing.T) {
+	dir := t.TempDir()
+	a := writeNamesCSV(t, dir, "a.csv", 2000, 1)
+	b := writeNamesCSV(t, dir, "b.csv", 2000, 1)
+	missing := filepath.Join(dir, "missing.csv")
+
+	single := presetConfig(t, "header", t.TempDir(), a)
+	one := runExtract(t, single)
+
+	config := presetConfig(t, "header", dir, a, b, missing)
+	stats, err := extractNames(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readLines(t, config.Output)
+
+	var unique []string
+	for i, name := range one {
+		if i == 0 || name != one[i-1] {
+			unique = append(unique, name)
+		}
+	}
+	if len(unique) == len(one) {
+		t.Fatal("the single-file output has no repeats to drop")
+	}
+	if !reflect.DeepEqual(got, unique) {
+		t.Errorf("merged output has %d names, want the %d distinct names of either file", len(got), len(unique))
+	}
+
+	if len(stats.Files) != 3 || stats.Files[2].Path != missing || stats.Files[2].Err == nil {
+		t.Fatalf("files %+v: want the missing file reported last", stats.Files)
+	}
+	for _, file := range stats.Files[:2] {
+		if file.Err != nil || file.Rows != 2000 {
+			t.Errorf("%s: %d rows, %v", file.Path, file.Rows, file.Err)
+		}
+	}
+}
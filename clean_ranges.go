@@ -0,0 +1,180 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// Ranges are cut so every worker gets some, but no bigger than
+// maxRangeSize and no smaller than minRangeSize bytes.
+const (
+	minRangeSize = 1024 * 1024
+	maxRangeSize = 16 * 1024 * 1024
+)
+
+// csvState is where a CSV scan stands after some byte. The states follow
+// encoding/csv, including how it recovers from malformed quotes, so that a
+// range split on a record boundary parses the same as reading the file
+// from the start.
+type csvState uint8
+
+const (
+	stateFieldStart csvState = iota // at the start of a field or record
+	stateUnquoted                   // inside an unquoted field
+	stateQuoted                     // inside a quoted field
+	stateQuote                      // just after a quote in a quoted field
+	stateBadLine                    // skipping the rest of a malformed line
+	csvStates
+)
+
+// step advances the scan over one byte and reports whether it ended a
+// record.
+func (s csvState) step(b byte) (csvState, bool) {
+	switch s {
+	case stateQuoted:
+		if b == '"' {
+			return stateQuote, false
+		}
+		return stateQuoted, false
+	case stateQuote:
+		switch b {
+		case '"':
+			return stateQuoted, false
+		case ',':
+			return stateFieldStart, false
+		case '\n':
+			return stateFieldStart, true
+		case '\r':
+			return stateQuote, false
+		}
+		return stateBadLine, false
+	case stateBadLine:
+		if b == '\n' {
+			return stateFieldStart, true
+		}
+		return stateBadLine, false
+	}
+
+	switch b {
+	case '\n':
+		return stateFieldStart, true
+	case ',':
+		return stateFieldStart, false
+	case '"':
+		if s == stateFieldStart {
+			return stateQuoted, false
+		}
+		return stateBadLine, false
+	}
+	return stateUnquoted, false
+}
+
+// rangeScan is what scanning one range found for each state the range might
+// start in: the offset just past its first record boundary (-1 if it has
+// none) and the state at its end.
+type rangeScan struct {
+	firstRecord [csvStates]int64
+	end         [csvStates]csvState
+}
+
+// scanRange runs the CSV state machine over file[start:end) from every
+// possible starting state at once.
+func scanRange(file io.ReaderAt, start, end int64) (rangeScan, error) {
+	var scan rangeScan
+	var states [csvStates]csvState
+	for s := range states {
+		states[s] = csvState(s)
+		scan.firstRecord[s] = -1
+	}
+
+	buf := make([]byte, 1024*1024)
+	for offset := start; offset < end; {
+		n, err := file.ReadAt(buf[:min(int64(len(buf)), end-offset)], offset)
+		ordinary := false
+		for i, b := range buf[:n] {
+			// Stepping over an ordinary byte twice lands where stepping
+			// once does, so runs of them only need the first.
+			if b != '"' && b != ',' && b != '\n' && b != '\r' {
+				if ordinary {
+					continue
+				}
+				ordinary = true
+			} else {
+				ordinary = false
+			}
+			for s := range states {
+				next, boundary := states[s].step(b)
+				states[s] = next
+				if boundary && scan.firstRecord[s] < 0 {
+					scan.firstRecord[s] = offset + int64(i) + 1
+				}
+			}
+		}
+		offset += int64(n)
+		if err != nil && !(err == io.EOF && offset == end) {
+			return scan, err
+		}
+	}
+	scan.end = states
+	return scan, nil
+}
+
+// byteRange is a part of the input that starts on a record boundary.
+type byteRange struct {
+	start, end int64
+}
+
+// splitRanges cuts file[dataStart:size) into ranges that each start on a
+// record boundary, scanning the pieces in parallel. dataStart must itself be
+// a record boundary.
+func splitRanges(file *os.File, dataStart, size int64, workers int) ([]byteRange, error) {
+	rangeSize := min(maxRangeSize, max(minRangeSize, (size-dataStart)/int64(workers)))
+	var pieces []byteRange
+	for start := dataStart; start < size; start += rangeSize {
+		pieces = append(pieces, byteRange{start, min(start+rangeSize, size)})
+	}
+	if len(pieces) <= 1 {
+		return pieces, nil
+	}
+
+	scans := make([]rangeScan, len(pieces))
+	errs := make([]error, len(pieces))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < min(workers, len(pieces)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range next {
+				scans[p], errs[p] = scanRange(file, pieces[p].start, pieces[p].end)
+			}
+		}()
+	}
+	for p := range pieces {
+		next <- p
+	}
+	close(next)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Only now is the state at the start of each piece known; follow it
+	// from dataStart to find where each range really begins. A piece with
+	// no record boundary, inside a very long quoted field, joins the range
+	// before it.
+	ranges := []byteRange{{start: dataStart}}
+	state := stateFieldStart
+	for p := 1; p < len(pieces); p++ {
+		state = scans[p-1].end[state]
+		if cut := scans[p].firstRecord[state]; cut >= 0 {
+			ranges[len(ranges)-1].end = cut
+			ranges = append(ranges, byteRange{start: cut})
+		}
+	}
+	ranges[len(ranges)-1].end = size
+	return ranges, nil
+}
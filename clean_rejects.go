@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// rejectLog records every value the rules rejected for -rejects. Each range
+// writes its own temporary part, so workers need no lock; finish joins the
+// parts in input order with rows counted from the start of each file.
+type rejectLog struct {
+	tempDir string
+	dirOnce sync.Once
+	dir     string
+	dirErr  error
+
+	parts []string
+	open  []*rejectPart
+}
+
+type rejectPart struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+func newRejectLog(tempDir string, ranges int) *rejectLog {
+	return &rejectLog{
+		tempDir: tempDir,
+		parts:   make([]string, ranges),
+		open:    make([]*rejectPart, ranges),
+	}
+}
+
+// record logs a value rejected in row of range r; only the worker parsing
+// range r may call it.
+func (l *rejectLog) record(r int, row int64, raw rawName, name, rejectedBy, detail string) error {
+	part := l.open[r]
+	if part == nil {
+		l.dirOnce.Do(func() {
+			l.dir, l.dirErr = os.MkdirTemp(l.tempDir, "clean-rejects-")
+		})
+		if l.dirErr != nil {
+			return fmt.Errorf("creating rejects directory: %w", l.dirErr)
+		}
+		path := filepath.Join(l.dir, fmt.Sprintf("part-%06d", r))
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		part = &rejectPart{file: file, writer: csv.NewWriter(bufio.NewWriterSize(file, rangeBufferSize))}
+		l.parts[r] = path
+		l.open[r] = part
+	}
+	return part.writer.Write([]string{raw.value, name, rejectedBy, detail, raw.source, strconv.FormatInt(row, 10)})
+}
+
+// closeRange finishes range r's part once the range is parsed.
+func (l *rejectLog) closeRange(r int) error {
+	part := l.open[r]
+	if part == nil {
+		return nil
+	}
+	l.open[r] = nil
+	part.writer.Flush()
+	if err := part.writer.Error(); err != nil {
+		part.file.Close()
+		return err
+	}
+	return part.file.Close()
+}
+
+// finish writes the value, name, rule, detail, source, source_file, row CSV
+// to path. rowOffsets and rangeFiles place each range as for
+// nameTally.frequencies.
+func (l *rejectLog) finish(path string, rowOffsets []int64, rangeFiles []string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(bufio.NewWriterSize(file, ioBufferSize))
+	writer.Write([]string{"value", "name", "rule", "detail", "source", "source_file", "row"})
+	for r, part := range l.parts {
+		if part == "" {
+			continue
+		}
+		if err := copyRejects(writer, part, rowOffsets[r], rangeFiles[r]); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// copyRejects appends one part to writer, adding the file and turning the
+// range's row numbers into the file's.
+func copyRejects(writer *csv.Writer, part string, rowOffset int64, sourceFile string) error {
+	file, err := os.Open(part)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(bufio.NewReaderSize(file, rangeBufferSize))
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		row, err := strconv.ParseInt(record[5], 10, 64)
+		if err != nil {
+			return err
+		}
+		writer.Write(append(record[:5], sourceFile, strconv.FormatInt(row+rowOffset, 10)))
+	}
+}
+
+// Close removes the parts.
+func (l *rejectLog) Close() error {
+	for r := range l.open {
+		l.closeRange(r)
+	}
+	if l.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(l.dir)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestAllFieldsRejects checks that the all-fields preset logs the values
+// its rules reject but not the blank fields every sparse row has.
+func TestAllFieldsRejects(t *testing.T) {
+	dir := t.TempDir()
+	config := presetConfig(t, "all-fields", dir, filepath.Join("testdata", "legacy", "names.csv"))
+	config.RejectsOutput = filepath.Join(dir, "rejects.csv")
+	if _, err := extractNames(config); err != nil {
+		t.Fatal(err)
+	}
+
+	rejects := readLines(t, config.RejectsOutput)
+	if len(rejects) < 2 {
+		t.Fatalf("no rejections logged: %q", rejects)
+	}
+	for _, line := range rejects[1:] {
+		if strings.Contains(line, ","+ruleEmpty+",") {
+			t.Errorf("blank field logged as a rejection: %q", line)
+		}
+	}
+}
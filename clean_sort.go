@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	// stringOverhead approximates what a string costs in a shard's names
+	// slice on top of its bytes, and setEntryOverhead what it costs in a
+	// shard's set.
+	stringOverhead   = 16
+	setEntryOverhead = 48
+)
+
+// nameSorter collects names from the workers and writes them sorted. Each
+// worker fills its own shard, so adding names takes no lock. Once a shard
+// holds more than its share of maxBytes it is sorted and spilled to a run
+// file; finish merges the runs, at most mergeWidth at a time, dropping
+// repeats while it goes. The output is the same as sorting everything in
+// memory.
+type nameSorter struct {
+	codec   entryCodec
+	tempDir string
+	dirOnce sync.Once
+	dir     string
+	dirErr  error
+
+	shards []*sortShard
+}
+
+// sortShard is the part of a nameSorter one worker owns. When only unique
+// names are wanted it keeps them in a set, so repeats cost no memory.
+type sortShard struct {
+	sorter   *nameSorter
+	id       int
+	maxBytes int64
+
+	names []string
+	seen  map[string]struct{}
+	bytes int64
+	found int64
+	runs  []string
+	err   error
+}
+
+// newNameSorter sizes a sorter with one shard per worker, splitting
+// config.MaxMemory between them. Shards deduplicate unless every name is
+// wanted in config.AllOutput.
+func newNameSorter(config ExtractConfig, codec entryCodec, workers int) *nameSorter {
+	s := &nameSorter{codec: codec, tempDir: config.TempDir}
+	for i := 0; i < workers; i++ {
+		shard := &sortShard{sorter: s, id: i, maxBytes: config.MaxMemory / int64(workers)}
+		if config.MaxMemory > 0 && shard.maxBytes == 0 {
+			shard.maxBytes = 1
+		}
+		if config.Dedup && config.AllOutput == "" {
+			shard.seen = make(map[string]struct{})
+		}
+		s.shards = append(s.shards, shard)
+	}
+	return s
+}
+
+// shard returns the shard for worker i. Only that worker may add to it.
+func (s *nameSorter) shard(i int) *sortShard {
+	return s.shards[i]
+}
+
+// runDir creates the directory for run files the first time one is needed.
+func (s *nameSorter) runDir() (string, error) {
+	s.dirOnce.Do(func() {
+		s.dir, s.dirErr = os.MkdirTemp(s.tempDir, "clean-sort-")
+		if s.dirErr != nil {
+			s.dirErr = fmt.Errorf("creating sort directory: %w", s.dirErr)
+		}
+	})
+	return s.dir, s.dirErr
+}
+
+// add takes a batch of entries; the caller may reuse the slice. After a failed
+// spill further names are dropped and finish reports the error.
+func (sh *sortShard) add(names []string) {
+	if sh.err != nil {
+		return
+	}
+
+	sh.found += int64(len(names))
+	for _, name := range names {
+		if sh.seen != nil {
+			if _, ok := sh.seen[name]; ok {
+				continue
+			}
+			sh.seen[name] = struct{}{}
+			sh.bytes += int64(len(name)) + setEntryOverhead
+			continue
+		}
+		sh.names = append(sh.names, name)
+		sh.bytes += int64(len(name)) + stringOverhead
+	}
+	if sh.maxBytes > 0 && sh.bytes >= sh.maxBytes {
+		sh.err = sh.spill()
+	}
+}
+
+// sorted returns the names held in memory, sorted, and empties the shard.
+func (sh *sortShard) sorted() []string {
+	names := sh.names
+	if sh.seen != nil {
+		names = make([]string, 0, len(sh.seen))
+		for name := range sh.seen {
+			names = append(names, name)
+		}
+		clear(sh.seen)
+	}
+	sort.Strings(names)
+	sh.names = nil
+	sh.bytes = 0
+	return names
+}
+
+// spill writes the names in memory to a new run file.
+func (sh *sortShard) spill() error {
+	dir, err := sh.sorter.runDir()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("run-%03d-%04d", sh.id, len(sh.runs)))
+	if err := writeRun(path, sh.sorted()); err != nil {
+		return err
+	}
+	sh.runs = append(sh.runs, path)
+	return nil
+}
+
+// found is how many names were added across all shards.
+func (s *nameSorter) found() int64 {
+	var found int64
+	for _, sh := range s.shards {
+		found += sh.found
+	}
+	return found
+}
+
+// runs lists the run files spilled across all shards.
+func (s *nameSorter) runs() []string {
+	var runs []string
+	for _, sh := range s.shards {
+		runs = append(runs, sh.runs...)
+	}
+	return runs
+}
+
+// finish writes every name, sorted, to allOutput when it is set and to
+// output, once each when dedup is set. It returns how many names went to
+// output. Call it once all workers are done.
+//
+// With provenance, the first file a name was found in goes with it.
+func (s *nameSorter) finish(output, allOutput string, dedup bool) (int64, error) {
+	for _, sh := range s.shards {
+		if sh.err != nil {
+			return 0, sh.err
+		}
+	}
+
+	if len(s.runs()) == 0 {
+		var names []string
+		for _, sh := range s.shards {
+			names = append(names, sh.sorted()...)
+		}
+		sort.Strings(names)
+		if allOutput != "" {
+			if err := writeEntries(allOutput, names, s.codec); err != nil {
+				return 0, err
+			}
+		}
+		if dedup {
+			names = s.codec.unique(names)
+		}
+		if err := writeEntries(output, names, s.codec); err != nil {
+			return 0, err
+		}
+		return int64(len(names)), nil
+	}
+
+	for _, sh := range s.shards {
+		if sh.bytes > 0 {
+			if err := sh.spill(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	// Repeats can go early only if no output wants every name.
+	runs, err := s.narrowRuns(s.runs(), dedup && allOutput == "")
+	if err != nil {
+		return 0, err
+	}
+	return mergeRuns(runs, output, allOutput, dedup, s.codec)
+}
+
+// narrowRuns merges runs mergeWidth at a time into new runs until no more
+// than mergeWidth are left, so the final merge, like every other, holds a
+// bounded number of files and buffers. unique drops repeated names as it
+// goes. Merged runs are removed once their replacement is written.
+func (s *nameSorter) narrowRuns(runs []string, unique bool) ([]string, error) {
+	for pass := 0; len(runs) > mergeWidth; pass++ {
+		dir, err := s.runDir()
+		if err != nil {
+			return nil, err
+		}
+		var next []string
+		for start := 0; start < len(runs); start += mergeWidth {
+			group := runs[start:min(start+mergeWidth, len(runs))]
+			if len(group) == 1 {
+				next = append(next, group[0])
+				continue
+			}
+			path := filepath.Join(dir, fmt.Sprintf("merge-%02d-%04d", pass, len(next)))
+			if err := s.mergeGroup(group, path, unique); err != nil {
+				return nil, err
+			}
+			for _, run := range group {
+				os.Remove(run)
+			}
+			next = append(next, path)
+		}
+		runs = next
+	}
+	return runs, nil
+}
+
+// mergeGroup merges runs into a single new run at path.
+func (s *nameSorter) mergeGroup(runs []string, path string, unique bool) error {
+	w, err := createRunWriter(path)
+	if err != nil {
+		return err
+	}
+	defer w.file.Close()
+
+	var last string
+	written := false
+	err = mergeSorted(runs, func(entry string) {
+		name := s.codec.name(entry)
+		if unique && written && name == last {
+			return
+		}
+		w.write(entry)
+		last, written = name, true
+	})
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// Close removes the run files.
+func (s *nameSorter) Close() error {
+	if s.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(s.dir)
+}
+
+// runWriter writes names as length-prefixed strings; names may contain
+// newlines, so a run cannot be split on them.
+type runWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+	prefix [binary.MaxVarintLen64]byte
+}
+
+func createRunWriter(path string) (*runWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &runWriter{file: file, writer: bufio.NewWriterSize(file, runBufferSize)}, nil
+}
+
+func (w *runWriter) write(name string) {
+	n := binary.PutUvarint(w.prefix[:], uint64(len(name)))
+	w.writer.Write(w.prefix[:n])
+	w.writer.WriteString(name)
+}
+
+func (w *runWriter) Close() error {
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// writeRun writes sorted names to a new run file.
+func writeRun(path string, names []string) error {
+	w, err := createRunWriter(path)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		w.write(name)
+	}
+	return w.Close()
+}
+
+// runReader steps through one run file.
+type runReader struct {
+	file    *os.File
+	reader  *bufio.Reader
+	current string
+}
+
+// next loads the following name into current, returning io.EOF at the end
+// of the run.
+func (r *runReader) next() error {
+	size, err := binary.ReadUvarint(r.reader)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r.reader, buf); err != nil {
+		return fmt.Errorf("reading %s: %w", r.file.Name(), err)
+	}
+	r.current = string(buf)
+	return nil
+}
+
+// runHeap orders run readers by their current name.
+type runHeap []*runReader
+
+func (h runHeap) Len() int           { return len(h) }
+func (h runHeap) Less(i, j int) bool { return h[i].current < h[j].current }
+func (h runHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)        { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// runBufferSize is the buffer each run is read and written through.
+const runBufferSize = 256 * 1024
+
+// mergeWidth is the most runs merged at once. With runBufferSize each, a
+// merge reads through at most 16 MB of buffers and holds as many files
+// open.
+var mergeWidth = 64
+
+// mergeSorted calls emit with every entry of runs in sorted order.
+func mergeSorted(runs []string, emit func(entry string)) error {
+	h := make(runHeap, 0, len(runs))
+	defer func() {
+		for _, r := range h {
+			r.file.Close()
+		}
+	}()
+	for _, path := range runs {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		r := &runReader{file: file, reader: bufio.NewReaderSize(file, runBufferSize)}
+		if err := r.next(); err != nil {
+			file.Close()
+			if err == io.EOF {
+				continue
+			}
+			return err
+		}
+		h = append(h, r)
+	}
+	heap.Init(&h)
+
+	for h.Len() > 0 {
+		r := h[0]
+		emit(r.current)
+
+		switch err := r.next(); err {
+		case nil:
+			heap.Fix(&h, 0)
+		case io.EOF:
+			heap.Pop(&h)
+			r.file.Close()
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeRuns merges sorted runs into output and, when set, allOutput. There
+// should be no more than mergeWidth of them; see narrowRuns.
+func mergeRuns(runs []string, output, allOutput string, dedup bool, codec entryCodec) (int64, error) {
+	writer, err := createEntryWriter(output, codec)
+	if err != nil {
+		return 0, err
+	}
+	defer writer.file.Close()
+
+	var allWriter *entryWriter
+	if allOutput != "" {
+		allWriter, err = createEntryWriter(allOutput, codec)
+		if err != nil {
+			return 0, err
+		}
+		defer allWriter.file.Close()
+	}
+
+	var written int64
+	var last string
+	err = mergeSorted(runs, func(entry string) {
+		if allWriter != nil {
+			allWriter.write(entry)
+		}
+		if name := codec.name(entry); !dedup || written == 0 || name != last {
+			writer.write(entry)
+			last = name
+			written++
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if allWriter != nil {
+		if err := allWriter.Close(); err != nil {
+			return 0, err
+		}
+	}
+	return written, writer.Close()
+}
+
+// provenanceSuffix is the length of what entryCodec appends to a name: a
+// NUL and the file index, fixed-width so entries sort by name first.
+const provenanceSuffix = 5
+
+// entryCodec encodes what the sorter carries for each name. Without
+// provenance an entry is just the name; with it, the name is followed by
+// the index of the file it came from, so sorting puts the first file first.
+type entryCodec struct {
+	files []string // set when entries carry provenance
+}
+
+func (c entryCodec) encode(name string, file int) string {
+	if c.files == nil {
+		return name
+	}
+	var suffix [provenanceSuffix]byte
+	binary.BigEndian.PutUint32(suffix[1:], uint32(file))
+	return name + string(suffix[:])
+}
+
+func (c entryCodec) name(entry string) string {
+	if c.files == nil {
+		return entry
+	}
+	return entry[:len(entry)-provenanceSuffix]
+}
+
+func (c entryCodec) file(entry string) string {
+	return c.files[binary.BigEndian.Uint32([]byte(entry[len(entry)-4:]))]
+}
+
+// unique drops entries repeating the name before them from a sorted slice,
+// in place.
+func (c entryCodec) unique(entries []string) []string {
+	if c.files == nil {
+		return uniqueSorted(entries)
+	}
+	unique := entries[:0]
+	for _, entry := range entries {
+		if len(unique) == 0 || c.name(entry) != c.name(unique[len(unique)-1]) {
+			unique = append(unique, entry)
+		}
+	}
+	return unique
+}
+
+// entryWriter writes entries as output lines: bare names, or name,
+// source_file CSV records when they carry provenance.
+type entryWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+	csv    *csv.Writer
+	codec  entryCodec
+}
+
+func createEntryWriter(path string, codec entryCodec) (*entryWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &entryWriter{file: file, writer: bufio.NewWriterSize(file, ioBufferSize), codec: codec}
+	if codec.files != nil {
+		w.csv = csv.NewWriter(w.writer)
+		w.csv.Write([]string{"name", "source_file"})
+	}
+	return w, nil
+}
+
+func (w *entryWriter) write(entry string) {
+	if w.csv != nil {
+		w.csv.Write([]string{w.codec.name(entry), w.codec.file(entry)})
+		return
+	}
+	w.writer.WriteString(entry + "\n")
+}
+
+func (w *entryWriter) Close() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// writeEntries writes sorted entries to path.
+func writeEntries(path string, entries []string, codec entryCodec) error {
+	w, err := createEntryWriter(path, codec)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		w.write(entry)
+	}
+	return w.Close()
+}
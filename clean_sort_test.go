@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// writeNamesCSV writes rows of generated names, with plenty of repeats, to
+// a new CSV file in dir.
+func writeNamesCSV(t testing.TB, dir, name string, rows, seed int) string {
+	t.Helper()
+	given := []string{"Ann", "Bob", "Émile", "Zoë", "Jo", "Mary Ann", "O'Brien", "Li", "Xavier", "Yusuf", "Chloé", "Sam"}
+	last := []string{"Smith", "Jones", "Zola", "Ünal", "Lee", "Nguyen", "Garcia", "Kowalski", "Okafor", "Tanaka"}
+	var buf bytes.Buffer
+	buf.WriteString("First Name,Middle,Company Name,Last Login,Last Name\n")
+	x := uint32(seed)
+	for i := 0; i < rows; i++ {
+		x = x*1664525 + 1013904223
+		// Letters rather than digits vary the names, so the strict preset
+		// keeps them too.
+		fmt.Fprintf(&buf, "%s%c%c,%c,Acme,2024-01-%02d,%s\n",
+			given[x>>8%uint32(len(given))], 'a'+rune(x>>16%26), 'a'+rune(x>>24%26), 'A'+rune(x>>4%26), x%28+1, last[x>>20%uint32(len(last))])
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestSpilledSortMatchesMemory runs each preset once in memory and once
+// with a cap small enough to spill at every batch, and requires
+// byte-identical outputs. mergeWidth is lowered so the runs take several
+// merge passes.
+func TestSpilledSortMatchesMemory(t *testing.T) {
+	defer func(width int) { mergeWidth = width }(mergeWidth)
+	mergeWidth = 3
+
+	inputDir := t.TempDir()
+	inputs := []string{
+		writeNamesCSV(t, inputDir, "a.csv", 20000, 1),
+		writeNamesCSV(t, inputDir, "b.csv", 20000, 2),
+	}
+
+	for _, preset := range presetNames() {
+		for _, provenance := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/source-file=%v", preset, provenance), func(t *testing.T) {
+				memDir, spillDir := t.TempDir(), t.TempDir()
+				inMemory := presetConfig(t, preset, memDir, inputs...)
+				inMemory.SourceFile = provenance
+				spilled := presetConfig(t, preset, spillDir, inputs...)
+				spilled.SourceFile = provenance
+				spilled.MaxMemory = 1
+
+				memStats, err := extractNames(inMemory)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if memStats.SortRuns != 0 {
+					t.Fatalf("in-memory run spilled %d runs", memStats.SortRuns)
+				}
+				spillStats, err := extractNames(spilled)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if spillStats.SortRuns <= mergeWidth*mergeWidth {
+					t.Fatalf("only %d runs spilled; the test needs more than %d", spillStats.SortRuns, mergeWidth*mergeWidth)
+				}
+				if spillStats.NamesWritten != memStats.NamesWritten {
+					t.Errorf("wrote %d names spilled, %d in memory", spillStats.NamesWritten, memStats.NamesWritten)
+				}
+
+				compareFiles(t, spilled.Output, inMemory.Output)
+				if inMemory.AllOutput != "" {
+					compareFiles(t, spilled.AllOutput, inMemory.AllOutput)
+				}
+
+				// Every run and intermediate merge is cleaned up.
+				if leftovers, _ := filepath.Glob(filepath.Join(spillDir, "clean-sort-*")); len(leftovers) > 0 {
+					t.Errorf("sort directories left behind: %v", leftovers)
+				}
+			})
+		}
+	}
+}
+
+func compareFiles(t *testing.T, got, want string) {
+	t.Helper()
+	gotData, err := os.ReadFile(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantData, err := os.ReadFile(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gotData, wantData) {
+		t.Errorf("%s differs from %s (%d bytes against %d)", got, want, len(gotData), len(wantData))
+	}
+}
+
+// lockedSorter is a single shard every worker adds to under one lock, as
+// the sorter was before each worker had its own shard.
+type lockedSorter struct {
+	mu    sync.Mutex
+	shard *sortShard
+}
+
+func (l *lockedSorter) add(names []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.shard.add(names)
+}
+
+// legacyShardCount is how many shards clean3.go and clean4.go split names
+// into, one per letter.
+const legacyShardCount = 26
+
+// mutexShards is the sharding clean3.go and clean4.go did: every worker
+// adds to every shard, picking one per name and holding that shard's lock
+// for it. Names are routed by hash rather than first byte, so the shards
+// fill evenly and only the locking differs.
+type mutexShards struct {
+	mu     [legacyShardCount]sync.Mutex
+	sorter *nameSorter
+}
+
+func (m *mutexShards) add(names []string) {
+	for i, name := range names {
+		h := fnv.New32a()
+		h.Write([]byte(name))
+		shard := h.Sum32() % legacyShardCount
+		m.mu[shard].Lock()
+		m.sorter.shard(int(shard)).add(names[i : i+1])
+		m.mu[shard].Unlock()
+	}
+}
+
+// BenchmarkShardAdd adds batches of sortBatchSize names from GOMAXPROCS
+// workers: each to its own shard, to legacyShardCount shards each behind
+// a mutex as the extractors this replaced did, or all to one shard behind
+// one mutex. An op is one batch.
+func BenchmarkShardAdd(b *testing.B) {
+	const distinct = 1 << 16
+	names := make([]string, distinct)
+	for i := range names {
+		names[i] = fmt.Sprintf("name-%06d smith", i*7919%distinct)
+	}
+
+	for _, dedup := range []bool{true, false} {
+		config := ExtractConfig{Dedup: dedup, MaxMemory: 256 << 20, TempDir: b.TempDir()}
+		workers := runtime.GOMAXPROCS(0)
+
+		b.Run(fmt.Sprintf("dedup=%v/shards", dedup), func(b *testing.B) {
+			sorter := newNameSorter(config, entryCodec{}, workers)
+			defer sorter.Close()
+			var next atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				shard := sorter.shard(int(next.Add(1)-1) % workers)
+				for i := 0; pb.Next(); i++ {
+					start := i * sortBatchSize % distinct
+					shard.add(names[start:min(start+sortBatchSize, distinct)])
+				}
+			})
+		})
+		b.Run(fmt.Sprintf("dedup=%v/mutex-per-shard", dedup), func(b *testing.B) {
+			sorter := newNameSorter(config, entryCodec{}, legacyShardCount)
+			defer sorter.Close()
+			sharded := &mutexShards{sorter: sorter}
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					start := i * sortBatchSize % distinct
+					sharded.add(names[start:min(start+sortBatchSize, distinct)])
+				}
+			})
+		})
+		b.Run(fmt.Sprintf("dedup=%v/mutex", dedup), func(b *testing.B) {
+			sorter := newNameSorter(config, entryCodec{}, 1)
+			defer sorter.Close()
+			locked := &lockedSorter{shard: sorter.shard(0)}
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					start := i * sortBatchSize % distinct
+					locked.add(names[start:min(start+sortBatchSize, distinct)])
+				}
+			})
+		})
+	}
+}
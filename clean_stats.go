@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxReportedLength is the longest name length the report lists on its own;
+// longer names share one line.
+const maxReportedLength = 40
+
+// nameCount is what a nameTally knows about one name. firstRange and
+// firstRow locate its first occurrence; rows are numbered within a range
+// until the tallies are merged.
+type nameCount struct {
+	count      int64
+	firstRange int
+	firstRow   int64
+	source     string
+	file       string
+}
+
+// before reports whether c was seen earlier in the input than other;
+// ranges are numbered across files in input order.
+func (c *nameCount) before(other *nameCount) bool {
+	if c.firstRange != other.firstRange {
+		return c.firstRange < other.firstRange
+	}
+	return c.firstRow < other.firstRow
+}
+
+// tallyEntryOverhead approximates what a distinct name costs in a
+// nameTally on top of its bytes: the map entry and its nameCount.
+const tallyEntryOverhead = 112
+
+// nameTally counts names and rejections for the -counts and -report
+// outputs. Like a sortShard, each worker has its own.
+//
+// Counting needs every distinct name in memory at once; unlike the sorter
+// the tally cannot spill. Once a tally holds more than maxBytes of names it
+// stops counting and err says so, and the run fails rather than going past
+// -max-memory.
+type nameTally struct {
+	counts   map[string]*nameCount
+	rejected map[string]int64
+	cleaned  int64
+	maxBytes int64
+	bytes    int64
+	err      error
+}
+
+func newNameTally(maxBytes int64) *nameTally {
+	return &nameTally{
+		counts:   make(map[string]*nameCount),
+		rejected: make(map[string]int64),
+		maxBytes: maxBytes,
+	}
+}
+
+// add records a kept name found in row of range r.
+func (t *nameTally) add(name, source string, r int, row int64) {
+	if c, ok := t.counts[name]; ok {
+		c.count++
+		return
+	}
+	if t.err != nil {
+		return
+	}
+	t.bytes += int64(len(name)) + tallyEntryOverhead
+	if t.maxBytes > 0 && t.bytes > t.maxBytes {
+		t.err = errors.New("counting names for -counts and -report needs more than half of -max-memory; raise -max-memory")
+		return
+	}
+	t.counts[name] = &nameCount{count: 1, firstRange: r, firstRow: row, source: source}
+}
+
+// merge folds other into t, keeping the earliest occurrence of each name.
+// Names are taken out of other as they go, so the two never both hold one.
+func (t *nameTally) merge(other *nameTally) {
+	for name, oc := range other.counts {
+		delete(other.counts, name)
+		c, ok := t.counts[name]
+		if !ok {
+			t.counts[name] = oc
+			continue
+		}
+		if oc.before(c) {
+			c.firstRange, c.firstRow, c.source = oc.firstRange, oc.firstRow, oc.source
+		}
+		c.count += oc.count
+	}
+	for reason, n := range other.rejected {
+		t.rejected[reason] += n
+	}
+	t.cleaned += other.cleaned
+}
+
+// nameFrequency is one line of the counts output.
+type nameFrequency struct {
+	name string
+	*nameCount
+}
+
+// frequencies lists the tallied names, most frequent first and then by
+// name. rowOffsets holds how many rows of its file come before each range
+// and rangeFiles which file it is, to turn the row numbers into ones
+// counted from the start of that file.
+func (t *nameTally) frequencies(rowOffsets []int64, rangeFiles []string) []nameFrequency {
+	list := make([]nameFrequency, 0, len(t.counts))
+	for name, c := range t.counts {
+		c.firstRow += rowOffsets[c.firstRange]
+		c.file = rangeFiles[c.firstRange]
+		list = append(list, nameFrequency{name, c})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].count != list[j].count {
+			return list[i].count > list[j].count
+		}
+		return list[i].name < list[j].name
+	})
+	return list
+}
+
+// writeCounts writes the name, count, first_row, source CSV, with a
+// source_file column when provenance is set.
+func writeCounts(path string, list []nameFrequency, provenance bool) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(bufio.NewWriterSize(file, ioBufferSize))
+	header := []string{"name", "count", "first_row", "source"}
+	if provenance {
+		header = append(header, "source_file")
+	}
+	writer.Write(header)
+	for _, f := range list {
+		record := []string{
+			f.name,
+			strconv.FormatInt(f.count, 10),
+			strconv.FormatInt(f.firstRow, 10),
+			f.source,
+		}
+		if provenance {
+			record = append(record, f.file)
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// characterSet sorts a name into a coarse class for the report.
+func characterSet(name string) string {
+	ascii, punctuation := true, false
+	for _, r := range name {
+		switch {
+		case unicode.IsDigit(r):
+			return "digits"
+		case unicode.IsLetter(r) || unicode.IsSpace(r):
+			if r >= utf8.RuneSelf {
+				ascii = false
+			}
+		case strings.ContainsRune("'-.,", r):
+			punctuation = true
+		default:
+			return "other symbols"
+		}
+	}
+	switch {
+	case punctuation:
+		return "letters and punctuation"
+	case ascii:
+		return "ASCII letters"
+	}
+	return "non-ASCII letters"
+}
+
+// writeReport summarizes a run: the topN most frequent names, how long
+// names are, which characters they use and why names were rejected. Every
+// occurrence of a name counts, not just the first.
+func writeReport(w io.Writer, config ExtractConfig, stats *ExtractStats, pipeline *namePipeline, tally *nameTally, list []nameFrequency, topN int) error {
+	lengths := make(map[int]int64)
+	charsets := make(map[string]int64)
+	for _, f := range list {
+		lengths[min(utf8.RuneCountInString(f.name), maxReportedLength)] += f.count
+		charsets[characterSet(f.name)] += f.count
+	}
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "Name report for %s\n\n", strings.Join(config.Inputs, ", "))
+	fmt.Fprintf(out, "Rows: %d (%d malformed rows skipped)\n", stats.Rows, stats.MalformedRows)
+	if len(stats.Files) > 1 {
+		writeFileStats(out, stats.Files)
+	}
+	fmt.Fprintf(out, "Names kept: %d (%d distinct)\n", stats.NamesFound, len(list))
+	if pipeline.clean {
+		fmt.Fprintf(out, "Names changed by cleaning: %d\n", tally.cleaned)
+	}
+
+	fmt.Fprintf(out, "\nTop %d names:\n", topN)
+	for i, f := range list[:min(topN, len(list))] {
+		fmt.Fprintf(out, "%5d. %-30s %10d\n", i+1, f.name, f.count)
+	}
+
+	fmt.Fprintln(out, "\nLength distribution (characters):")
+	var sizes []int
+	for size := range lengths {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+	for _, size := range sizes {
+		label := strconv.Itoa(size)
+		if size == maxReportedLength {
+			label += "+"
+		}
+		fmt.Fprintf(out, "%6s %10d %6.2f%%\n", label, lengths[size], percent(lengths[size], stats.NamesFound))
+	}
+
+	fmt.Fprintln(out, "\nCharacter sets:")
+	writeBreakdown(out, charsets, stats.NamesFound)
+
+	var rejected int64
+	for _, n := range tally.rejected {
+		rejected += n
+	}
+	fmt.Fprintf(out, "\nRejected names by rule: %d\n", rejected)
+	writeBreakdown(out, tally.rejected, rejected)
+
+	return out.Flush()
+}
+
+// writeFileStats lists the rows each input file had, or why it failed.
+func writeFileStats(w io.Writer, files []FileStats) {
+	for _, file := range files {
+		fmt.Fprintf(w, "  %s: %d rows, %d malformed", file.Path, file.Rows, file.MalformedRows)
+		if file.Err != nil {
+			fmt.Fprintf(w, ", failed: %v", file.Err)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// writeBreakdown lists counts, largest first, with their share of total.
+func writeBreakdown(w io.Writer, counts map[string]int64, total int64) {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "  %-30s %10d %6.2f%%\n", key, counts[key], percent(counts[key], total))
+	}
+}
+
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestTallyMemoryLimit checks that counting names keeps to its half of
+// MaxMemory: a run with more distinct names than fit fails instead of
+// counting past it.
+func TestTallyMemoryLimit(t *testing.T) {
+	dir := t.TempDir()
+	input := writeNamesCSV(t, dir, "names.csv", 20000, 1)
+
+	config := presetConfig(t, "given-last", dir, input)
+	config.CountsOutput = filepath.Join(dir, "counts.csv")
+	if _, err := extractNames(config); err != nil {
+		t.Fatalf("with room to count: %v", err)
+	}
+	counts := readLines(t, config.CountsOutput)
+	if want := readLines(t, config.Output); len(counts) != len(want)+1 {
+		t.Fatalf("counted %d names, wrote %d", len(counts)-1, len(want))
+	}
+
+	config.MaxMemory = 64 * 1024
+	_, err := extractNames(config)
+	if err == nil || !strings.Contains(err.Error(), "-max-memory") {
+		t.Fatalf("got %v, want the tally over its share of -max-memory", err)
+	}
+}
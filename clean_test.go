@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// presetConfig is a preset as main runs it with default flags, reading
+// inputs and writing every output into dir.
+func presetConfig(t testing.TB, preset, dir string, inputs ...string) ExtractConfig {
+	t.Helper()
+	config, ok := presets[preset]
+	if !ok {
+		t.Fatalf("unknown preset %q", preset)
+	}
+	config.Inputs = inputs
+	config.Workers = 4
+	config.MinConfidence = 0.5
+	config.MaxMemory = 1024 * 1024 * 1024
+	config.TempDir = dir
+	config.TopNames = 20
+	config.Output = filepath.Join(dir, "out.txt")
+	if config.AllOutput != "" {
+		config.AllOutput = filepath.Join(dir, "all.txt")
+	}
+	return config
+}
+
+// runExtract runs config and returns its output file's lines.
+func runExtract(t testing.TB, config ExtractConfig) []string {
+	t.Helper()
+	if _, err := extractNames(config); err != nil {
+		t.Fatal(err)
+	}
+	return readLines(t, config.Output)
+}
+
+func readLines(t testing.TB, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// outputChange is one deliberate difference between a preset and the
+// extractor it replaced: a line the preset no longer writes, or writes
+// instead.
+type outputChange struct {
+	removed, added string
+	why            string
+}
+
+// The goldens in testdata/legacy are what each extractor removed by the
+// merge wrote for testdata/legacy/names.csv, run unchanged from the commit
+// before it (clean7.go only after dropping two unused variables it did not
+// compile with). clean7_all.golden is clean7.go's all_names.txt.
+var legacyExtractors = []struct {
+	legacy   string
+	preset   string
+	all      bool // compare the AllOutput file instead of Output
+	intended []outputChange
+}{
+	{legacy: "clean", preset: "given-last"},
+	{legacy: "Clean1", preset: "given-last"},
+	{legacy: "clean2", preset: "given-last"},
+	{legacy: "clean3", preset: "given-last"},
+	{legacy: "clean4", preset: "given-last-strict", intended: []outputChange{
+		{removed: "ann", why: "a last name of only spaces counts as blank once trimmed"},
+		{removed: "bob    jones", why: "trimmed before joining, it is the bob jones already written"},
+		{removed: "tom   hanks", added: "tom hanks", why: "given and last names are trimmed before joining"},
+	}},
+	{legacy: "clean5", preset: "header", intended: headerChanges},
+	{legacy: "clean6", preset: "header", intended: headerChanges},
+	{legacy: "clean7", preset: "all-fields"},
+	{legacy: "clean7_all", preset: "all-fields", all: true},
+}
+
+// headerChanges come from classifying header columns by synonyms and values
+// rather than matching "name", "first", "last" or "given" anywhere in them.
+var headerChanges = []outputChange{
+	{removed: "alice acme 2024-01-02 smith", added: "alice smith", why: "Company Name and Last Login are not name columns"},
+	{removed: "alice acme corp 2024-02-02 smith", added: "alice smith", why: "Company Name and Last Login are not name columns"},
+	{removed: "ann globex", added: "ann", why: "Company Name is not a name column"},
+	{removed: "bob initech 2024-01-03 jones", added: "bob j jones", why: "Middle is a name column; Company Name and Last Login are not"},
+	{removed: "bob initech jones", added: "bob jones", why: "Company Name is not a name column"},
+	{removed: "zoë ünal", added: "zoë q ünal", why: "Middle is a name column"},
+}
+
+// TestLegacyGoldens checks each preset against the extractor it replaced,
+// allowing only the differences listed in legacyExtractors.
+func TestLegacyGoldens(t *testing.T) {
+	for _, tt := range legacyExtractors {
+		t.Run(tt.legacy, func(t *testing.T) {
+			dir := t.TempDir()
+			config := presetConfig(t, tt.preset, dir, filepath.Join("testdata", "legacy", "names.csv"))
+			if _, err := extractNames(config); err != nil {
+				t.Fatal(err)
+			}
+			output := config.Output
+			if tt.all {
+				output = config.AllOutput
+			}
+			golden := filepath.Join("testdata", "legacy", tt.legacy+".golden")
+			if len(tt.intended) == 0 {
+				got, _ := os.ReadFile(output)
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != string(want) {
+					t.Errorf("preset %s differs from %s.go:\n got %q\nwant %q", tt.preset, tt.legacy, got, want)
+				}
+				return
+			}
+
+			// Lines are compared as sorted sets: an added line may belong
+			// elsewhere in the output, and names can span lines.
+			got := readLines(t, output)
+			want := readLines(t, golden)
+			for _, change := range tt.intended {
+				if change.removed != "" {
+					i := indexOf(want, change.removed)
+					if i < 0 {
+						t.Fatalf("intended change %q -> %q: %q is not in the golden", change.removed, change.added, change.removed)
+					}
+					want = append(want[:i], want[i+1:]...)
+				}
+				if change.added != "" {
+					want = append(want, change.added)
+				}
+			}
+			sort.Strings(got)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("preset %s differs from %s.go beyond the intended changes:\n got %q\nwant %q", tt.preset, tt.legacy, got, want)
+			}
+		})
+	}
+}
+
+func indexOf(lines []string, line string) int {
+	for i, l := range lines {
+		if l == line {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var spaceRuns = regexp.MustCompile(`\s+`)
+
+// Rules configures how raw names are normalized and which are kept. A
+// -rules file holds them as JSON, for example:
+//
+//	{
+//	  "clean": true,
+//	  "allowed_punctuation": "'-",
+//	  "letters_only": true,
+//	  "min_length": 2,
+//	  "max_length": 40,
+//	  "scripts": ["Latin"],
+//	  "reject_patterns": ["^test\\b", "^(asdf|qwerty)"],
+//	  "blocklists": ["profanity.txt", "test_names.txt"]
+//	}
+//
+// Clean strips everything but letters, spaces and AllowedPunctuation, where
+// LettersOnly rejects names containing anything else. Lengths count
+// characters unless LengthUnit is "bytes". Blocklists are files of one
+// entry per line, relative to the rules file; a name is blocked when it or
+// any of its words is listed there or in Blocked. Rules see names lower-cased
+// and normalized. Without a -rules file the rules come from -clean,
+// -letters and -min-length.
+type Rules struct {
+	Clean              bool     `json:"clean"`
+	AllowedPunctuation string   `json:"allowed_punctuation"`
+	LettersOnly        bool     `json:"letters_only"`
+	MinLength          int      `json:"min_length"`
+	MaxLength          int      `json:"max_length"`
+	LengthUnit         string   `json:"length_unit"`
+	Scripts            []string `json:"scripts"`
+	RejectPatterns     []string `json:"reject_patterns"`
+	Blocklists         []string `json:"blocklists"`
+	Blocked            []string `json:"blocked"`
+}
+
+// Names of the rules a namePipeline can reject a value with.
+const (
+	ruleEmpty       = "empty"
+	ruleCleaned     = "cleaned-empty"
+	ruleMinLength   = "min-length"
+	ruleMaxLength   = "max-length"
+	ruleLettersOnly = "letters-only"
+	ruleScript      = "script"
+	rulePattern     = "pattern"
+	ruleBlocklist   = "blocklist"
+)
+
+// loadRules reads a rules file, resolving its blocklists against the
+// file's directory.
+func loadRules(path string) (Rules, error) {
+	var rules Rules
+	file, err := os.Open(path)
+	if err != nil {
+		return rules, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&rules); err != nil {
+		return rules, fmt.Errorf("reading rules %s: %w", path, err)
+	}
+	for i, list := range rules.Blocklists {
+		if !filepath.IsAbs(list) {
+			rules.Blocklists[i] = filepath.Join(filepath.Dir(path), list)
+		}
+	}
+	return rules, nil
+}
+
+// rulesFromConfig gives the rules the -clean, -letters and -min-length flags
+// stand for. -min-length has always counted bytes.
+func rulesFromConfig(config ExtractConfig) Rules {
+	return Rules{
+		Clean:       config.Clean,
+		LettersOnly: config.Letters,
+		MinLength:   config.MinLength,
+		LengthUnit:  "bytes",
+	}
+}
+
+// rule is one check of a namePipeline. check reports whether a normalized
+// name passes and, when it does not, may say why, e.g. which pattern
+// matched.
+type rule struct {
+	name  string
+	check func(name string) (ok bool, detail string)
+}
+
+// namePipeline normalizes raw names and runs them through rules in order;
+// the first rule a name fails rejects it.
+type namePipeline struct {
+	clean   bool
+	allowed string
+	rules   []rule
+}
+
+func newNamePipeline(rules Rules) (*namePipeline, error) {
+	p := &namePipeline{clean: rules.Clean, allowed: rules.AllowedPunctuation}
+
+	length := utf8.RuneCountInString
+	switch rules.LengthUnit {
+	case "", "characters":
+	case "bytes":
+		length = func(s string) int { return len(s) }
+	default:
+		return nil, fmt.Errorf("unknown length_unit %q (use characters or bytes)", rules.LengthUnit)
+	}
+	if rules.MinLength > 0 {
+		p.rules = append(p.rules, rule{ruleMinLength, func(name string) (bool, string) {
+			return length(name) >= rules.MinLength, ""
+		}})
+	}
+	if rules.MaxLength > 0 {
+		p.rules = append(p.rules, rule{ruleMaxLength, func(name string) (bool, string) {
+			return length(name) <= rules.MaxLength, ""
+		}})
+	}
+
+	if rules.LettersOnly {
+		p.rules = append(p.rules, rule{ruleLettersOnly, func(name string) (bool, string) {
+			for _, r := range name {
+				if !p.allowedRune(r) {
+					return false, string(r)
+				}
+			}
+			return true, ""
+		}})
+	}
+
+	if len(rules.Scripts) > 0 {
+		var tables []*unicode.RangeTable
+		for _, script := range rules.Scripts {
+			table, ok := unicode.Scripts[script]
+			if !ok {
+				return nil, fmt.Errorf("unknown script %q", script)
+			}
+			tables = append(tables, table)
+		}
+		p.rules = append(p.rules, rule{ruleScript, func(name string) (bool, string) {
+			for _, r := range name {
+				if unicode.IsLetter(r) && !unicode.IsOneOf(tables, r) {
+					return false, string(r)
+				}
+			}
+			return true, ""
+		}})
+	}
+
+	for _, pattern := range rules.RejectPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("reject pattern: %w", err)
+		}
+		p.rules = append(p.rules, rule{rulePattern, func(name string) (bool, string) {
+			return !re.MatchString(name), pattern
+		}})
+	}
+
+	blocked := make(map[string]string)
+	for _, entry := range rules.Blocked {
+		blocked[strings.ToLower(strings.TrimSpace(entry))] = "blocked"
+	}
+	for _, list := range rules.Blocklists {
+		if err := readBlocklist(list, blocked); err != nil {
+			return nil, err
+		}
+	}
+	if len(blocked) > 0 {
+		p.rules = append(p.rules, rule{ruleBlocklist, func(name string) (bool, string) {
+			if list, ok := blocked[name]; ok {
+				return false, list
+			}
+			for _, word := range strings.Fields(name) {
+				if list, ok := blocked[word]; ok {
+					return false, list
+				}
+			}
+			return true, ""
+		}})
+	}
+	return p, nil
+}
+
+// readBlocklist adds the entries of a blocklist file to blocked, mapped to
+// the file's name. Blank lines and lines starting with # are skipped.
+func readBlocklist(path string, blocked map[string]string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if entry != "" && !strings.HasPrefix(entry, "#") {
+			blocked[entry] = filepath.Base(path)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading blocklist %s: %w", path, err)
+	}
+	return nil
+}
+
+// apply normalizes raw and runs the rules over it. It returns the
+// normalized name and, when it is rejected, the rule that did so with any
+// detail.
+func (p *namePipeline) apply(raw string) (name, rejectedBy, detail string) {
+	name = p.normalize(raw)
+	if name == "" {
+		if p.clean && strings.TrimSpace(raw) != "" {
+			return name, ruleCleaned, ""
+		}
+		return name, ruleEmpty, ""
+	}
+	for _, r := range p.rules {
+		if ok, detail := r.check(name); !ok {
+			return name, r.name, detail
+		}
+	}
+	return name, "", ""
+}
+
+// normalize lower-cases a raw name and, when cleaning, strips it down to
+// letters, allowed punctuation and single spaces.
+func (p *namePipeline) normalize(raw string) string {
+	name := strings.ToLower(raw)
+	if !p.clean {
+		return strings.TrimSpace(name)
+	}
+
+	var result strings.Builder
+	for _, r := range name {
+		if p.allowedRune(r) {
+			result.WriteRune(r)
+		}
+	}
+	return strings.TrimSpace(spaceRuns.ReplaceAllString(result.String(), " "))
+}
+
+func (p *namePipeline) allowedRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsSpace(r) || strings.ContainsRune(p.allowed, r)
+}
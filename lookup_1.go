@@ -1,51 +1,95 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/jszwec/csvutil"
-	"github.com/schollz/progressbar/v3"
-	"github.com/syndtr/goleveldb/leveldb"
-	"github.com/syndtr/goleveldb/leveldb/util"
 	"golang.org/x/text/language"
 	"golang.org/x/text/search"
 )
 
+const (
+	lookupPrefixLen = 3
+	metaKey         = "\x00meta"
+	writeBatchSize  = 10000
+
+	// cancelCheckInterval is how many entries a bucket scan gets through
+	// between checks of its context.
+	cancelCheckInterval = 1024
+)
+
 type Config struct {
-	WorkerCount int `json:"worker_count"`
-	BatchSize   int `json:"batch_size"`
-	BufferSize  int `json:"buffer_size"`
+	WorkerCount    int           `json:"worker_count"`
+	BatchSize      int           `json:"batch_size"`
+	BufferSize     int           `json:"buffer_size"`
+	CheckpointRows int           `json:"checkpoint_rows"`
+	Backend        string        `json:"backend"`
+	DBPath         string        `json:"db_path"`
+	SnapshotPath   string        `json:"snapshot_path"`
+	MemoryIndex    bool          `json:"memory_index"`
+	LookupFormat   string        `json:"lookup_format"`
+	LookupKey      string        `json:"lookup_key"`
+	Modes          []string      `json:"modes"`
+	OutputFormat   string        `json:"output_format"`
+	InputFormat    FileFormat    `json:"input_format"`
+	Lenient        bool          `json:"lenient"`
+	MaxErrors      int           `json:"max_errors"`
+	RequestTimeout time.Duration `json:"request_timeout"`
+
+	// Progress is told about lookup loads and file runs. NewServer falls
+	// back to terminal progress bars when it is nil.
+	Progress ProgressReporter `json:"-"`
+}
+
+// DictionaryMeta describes the contents of a lookup store. It is kept in the
+// store under metaKey and travels with snapshots.
+type DictionaryMeta struct {
+	Version       string   `json:"version"`
+	Source        string   `json:"source"`
+	Entries       int64    `json:"entries"`
+	PrefixLen     int      `json:"prefix_len"`
+	Normalization string   `json:"normalization"`
+	PayloadFields []string `json:"payload_fields,omitempty"`
 }
 
 type Metrics struct {
 	ProcessedRecords int64         `json:"processed_records"`
 	MatchedRecords   int64         `json:"matched_records"`
+	ResumedRecords   int64         `json:"resumed_records,omitempty"`
+	RejectedRecords  int64         `json:"rejected_records"`
 	ProcessingTime   time.Duration `json:"processing_time"`
 }
 
 type Record struct {
-	Name         string `csv:"name"`
-	Result       bool   `csv:"lookup_result"`
-	MatchedValue string `csv:"matched_value"`
-	MatchType    string `csv:"match_type"`
+	Name         string            `csv:"name"`
+	Result       bool              `csv:"lookup_result"`
+	MatchedValue string            `csv:"matched_value"`
+	MatchType    string            `csv:"match_type"`
+	Payload      map[string]string `csv:"-"`
 }
 
 type CacheEntry struct {
 	matchedValue string
 	matchType    string
+	payload      map[string]string
 }
 
 type CacheStats struct {
@@ -54,7 +98,14 @@ type CacheStats struct {
 }
 
 type Server struct {
-	db         *leveldb.DB
+	db         Backend
+	index      *lookupIndex
+	dbMu       sync.RWMutex
+	meta       DictionaryMeta
+	loadedAt   time.Time
+	lookupFile string
+	reloadMu   sync.Mutex
+	reloadErr  atomic.Value
 	matcher    *search.Matcher
 	config     Config
 	matchCache sync.Map
@@ -62,24 +113,51 @@ type Server struct {
 }
 
 type StringLookupRequest struct {
-	SearchString string `json:"search_string"`
+	SearchString string   `json:"search_string"`
+	Modes        []string `json:"modes"`
+	Timeout      string   `json:"timeout"`
 }
 
 type StringLookupResponse struct {
-	Found        bool   `json:"found"`
-	MatchedValue string `json:"matched_value"`
-	MatchType    string `json:"match_type"`
-	CacheHit     bool   `json:"cache_hit"`
+	Found        bool              `json:"found"`
+	MatchedValue string            `json:"matched_value"`
+	MatchType    string            `json:"match_type"`
+	Payload      map[string]string `json:"payload,omitempty"`
+	CacheHit     bool              `json:"cache_hit"`
 }
 
 type FileProcessRequest struct {
-	InputFilePath string   `json:"input_file_path"`
-	SearchColumns []string `json:"search_columns"`
+	InputFilePath string      `json:"input_file_path"`
+	SearchColumns []string    `json:"search_columns"`
+	Modes         []string    `json:"modes"`
+	OutputFormat  string      `json:"output_format"`
+	InputFormat   *FileFormat `json:"input_format"`
+	Resume        bool        `json:"resume"`
+	Lenient       bool        `json:"lenient"`
+	MaxErrors     int         `json:"max_errors"`
+	Timeout       string      `json:"timeout"`
+}
+
+// ProcessOptions controls a single processInputFile run. Modes must already
+// have been through parseModes and OutputFormat through parseOutputFormat.
+// Unset InputFormat fields are sniffed from the file. Resume continues from
+// the checkpoint left by an interrupted run of the same file, if any.
+// Lenient skips malformed rows into a rejects sidecar instead of failing,
+// giving up once more than MaxErrors rows are rejected (0 means no limit).
+type ProcessOptions struct {
+	SearchColumns []string   `json:"search_columns"`
+	Modes         []string   `json:"modes"`
+	OutputFormat  string     `json:"output_format"`
+	InputFormat   FileFormat `json:"input_format"`
+	Lenient       bool       `json:"lenient"`
+	MaxErrors     int        `json:"max_errors"`
+	Resume        bool       `json:"-"`
 }
 
 type FileProcessResponse struct {
 	Metrics       *Metrics `json:"metrics"`
 	ProcessedPath string   `json:"processed_path"`
+	RejectsPath   string   `json:"rejects_path,omitempty"`
 	CacheStats    struct {
 		Hits   uint64 `json:"cache_hits"`
 		Misses uint64 `json:"cache_misses"`
@@ -87,134 +165,525 @@ type FileProcessResponse struct {
 }
 
 func NewServer(lookupFile string, config Config) (*Server, error) {
-	db, err := leveldb.OpenFile("lookup.db", nil)
+	if config.DBPath == "" {
+		config.DBPath = "lookup.db"
+	}
+	if config.Progress == nil {
+		config.Progress = terminalReporter{}
+	}
+
+	// The memory backend has no directory to restore into, so a snapshot is
+	// loaded straight into it once it is open.
+	inMemory := config.Backend == "memory"
+	if config.SnapshotPath != "" && !inMemory {
+		if _, err := restoreSnapshot(config.SnapshotPath, config.Backend, config.DBPath); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := openBackend(config.Backend, config.DBPath)
 	if err != nil {
 		return nil, err
 	}
+	if config.SnapshotPath != "" && inMemory {
+		if _, err := loadSnapshotInto(config.SnapshotPath, db); err != nil {
+			return nil, err
+		}
+	}
 
 	server := &Server{
-		db:      db,
-		matcher: search.New(language.English, search.Loose),
-		config:  config,
+		db:         db,
+		lookupFile: lookupFile,
+		loadedAt:   time.Now(),
+		matcher:    search.New(language.English, search.Loose),
+		config:     config,
 	}
 
-	if err := server.loadLookupData(lookupFile); err != nil {
+	if lookupFile != "" {
+		if err := server.loadLookupData(lookupFile); err != nil {
+			db.Close()
+			return nil, err
+		}
+	} else if server.meta, err = readDictionaryMeta(db); err != nil {
+		db.Close()
 		return nil, err
+	} else if config.MemoryIndex {
+		if server.index, err = buildLookupIndex(db); err != nil {
+			db.Close()
+			return nil, err
+		}
 	}
 
 	return server, nil
 }
 
+func (s *Server) Close() error {
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+	return s.db.Close()
+}
+
+func isMetaKey(key []byte) bool {
+	return bytes.Equal(key, []byte(metaKey))
+}
+
+func readDictionaryMeta(db Backend) (DictionaryMeta, error) {
+	meta := DictionaryMeta{PrefixLen: lookupPrefixLen, Normalization: "lower-trim"}
+	data, err := db.Get([]byte(metaKey))
+	if err == errNotFound {
+		return meta, nil
+	}
+	if err != nil {
+		return meta, err
+	}
+	err = json.Unmarshal(data, &meta)
+	return meta, err
+}
+
+func writeDictionaryMeta(batch *WriteBatch, meta DictionaryMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	batch.Put([]byte(metaKey), data)
+	return nil
+}
+
 func (s *Server) loadLookupData(lookupFile string) error {
-	file, err := os.Open(lookupFile)
+	meta, err := buildLookupDB(context.Background(), s.db, lookupFile, s.config.LookupFormat, s.config.LookupKey, s.config.Progress)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	s.meta = meta
+
+	if s.config.MemoryIndex {
+		if s.index, err = buildLookupIndex(s.db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	batch := new(leveldb.Batch)
-	scanner := bufio.NewScanner(file)
-	bar := progressbar.Default(-1, "Loading Lookup Data")
+// buildLookupDB loads lookupFile into db, flushing every writeBatchSize
+// entries so large lists never sit in memory as a single batch. format and
+// keyColumn are passed through to readLookupSource; ctx is only used to
+// report progress.
+func buildLookupDB(ctx context.Context, db Backend, lookupFile, format, keyColumn string, progress ProgressReporter) (DictionaryMeta, error) {
+	batch := new(WriteBatch)
+	bar := progress.Start(ctx, "Loading Lookup Data", lookupFile, -1)
+	defer bar.Finish()
+	hasher := sha256.New()
+	var entries int64
+
+	payloadFields, err := readLookupSource(lookupFile, format, keyColumn, func(raw string, payload map[string]string) error {
+		defer bar.Add(1)
+
+		value := normalizeLookupValue(raw)
+		if len(value) == 0 {
+			return nil
+		}
 
-	for scanner.Scan() {
-		value := strings.ToLower(strings.TrimSpace(scanner.Text()))
-		if len(value) > 0 {
-			prefixLen := min(3, len(value))
-			prefix := value[:prefixLen]
-			key := prefix + ":" + value
-			batch.Put([]byte(key), []byte{1})
+		data, err := encodePayload(payload)
+		if err != nil {
+			return err
+		}
+		batch.Put([]byte(lookupKey(value)), data)
+		hasher.Write([]byte(value + "\n"))
+		if len(payload) > 0 {
+			hasher.Write(append(data, '\n'))
 		}
-		bar.Add(1)
+		entries++
+
+		if batch.Len() >= writeBatchSize {
+			if err := db.Write(batch); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		return nil
+	})
+	if err != nil {
+		return DictionaryMeta{}, err
+	}
+
+	meta := DictionaryMeta{
+		Version:       hex.EncodeToString(hasher.Sum(nil))[:16],
+		Source:        lookupFile,
+		Entries:       entries,
+		PrefixLen:     lookupPrefixLen,
+		Normalization: "lower-trim",
+		PayloadFields: payloadFields,
+	}
+	if err := writeDictionaryMeta(batch, meta); err != nil {
+		return DictionaryMeta{}, err
 	}
 
-	return s.db.Write(batch, nil)
+	return meta, db.Write(batch)
 }
 
-func (s *Server) lookupWithCache(searchValue string) (bool, string, string) {
-	if entry, ok := s.matchCache.Load(searchValue); ok {
+// lookupWithCache runs performLookup with modes, which must already have been
+// through parseModes. Results are cached per mode list, since the same search
+// string can match differently under another one. A lookup cut short by ctx
+// returns ctx's error and is not cached.
+func (s *Server) lookupWithCache(ctx context.Context, searchValue string, modes []string) (bool, string, string, map[string]string, error) {
+	// Held across the lookup and the cache store so a reload cannot swap the
+	// store and clear the cache in between.
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+
+	cacheKey := modesKey(modes) + "\x00" + searchValue
+	if entry, ok := s.matchCache.Load(cacheKey); ok {
 		atomic.AddUint64(&s.cacheStats.hits, 1)
 		cacheEntry := entry.(CacheEntry)
-		return true, cacheEntry.matchedValue, cacheEntry.matchType
+		return true, cacheEntry.matchedValue, cacheEntry.matchType, cacheEntry.payload, nil
 	}
 
 	atomic.AddUint64(&s.cacheStats.misses, 1)
-	found, matchedValue, matchType := s.performLookup(searchValue)
+	found, matchedValue, matchType, payload, err := s.performLookup(ctx, searchValue, modes)
+	if err != nil {
+		return false, "", "", nil, err
+	}
 
 	if found {
-		s.matchCache.Store(searchValue, CacheEntry{
+		s.matchCache.Store(cacheKey, CacheEntry{
 			matchedValue: matchedValue,
 			matchType:    matchType,
+			payload:      payload,
 		})
 	}
 
-	return found, matchedValue, matchType
+	return found, matchedValue, matchType, payload, nil
 }
 
-func (s *Server) performLookup(searchValue string) (bool, string, string) {
-	searchValue = strings.ToLower(searchValue)
+// performLookup tries each mode in order against the search value's prefix
+// bucket; the first mode with a matching entry wins. Every mode, fuzzy and
+// phonetic included, only sees entries sharing the first lookupPrefixLen
+// characters.
+func (s *Server) performLookup(ctx context.Context, searchValue string, modes []string) (bool, string, string, map[string]string, error) {
+	searchValue = normalizeLookupValue(searchValue)
 	if len(searchValue) == 0 {
-		return false, "", ""
+		return false, "", "", nil, nil
 	}
 
-	prefixLen := min(3, len(searchValue))
+	prefixLen := min(lookupPrefixLen, len(searchValue))
 	prefix := searchValue[:prefixLen]
 
-	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix+":")), nil)
-	defer iter.Release()
+	for _, mode := range modes {
+		if err := ctx.Err(); err != nil {
+			return false, "", "", nil, err
+		}
 
-	for iter.Next() {
-		key := string(iter.Key())
-		lookupValue := strings.TrimPrefix(key, prefix+":")
+		if mode == modeExact {
+			value, ok, err := s.getExact(lookupKey(searchValue))
+			if err != nil {
+				return false, "", "", nil, err
+			}
+			if ok {
+				return true, searchValue, matchTypes[modeExact], decodePayload(value), nil
+			}
+			continue
+		}
 
-		if start, _ := s.matcher.IndexString(searchValue, lookupValue); start != -1 {
-			return true, lookupValue, "contains_lookup"
+		// Each mode walks the bucket itself and stops at its first match,
+		// so an early hit never reads the rest of the bucket.
+		var matchedValue string
+		var payload map[string]string
+		found := false
+		err := s.scanBucket(ctx, prefix+":", func(key string, value []byte) bool {
+			lookupValue := key[len(prefix)+1:]
+			if !s.matchMode(mode, searchValue, lookupValue) {
+				return true
+			}
+			matchedValue, payload, found = lookupValue, decodePayload(value), true
+			return false
+		})
+		if err != nil {
+			return false, "", "", nil, err
 		}
-		if start, _ := s.matcher.IndexString(lookupValue, searchValue); start != -1 {
-			return true, lookupValue, "lookup_contains"
+		if found {
+			return true, matchedValue, matchTypes[mode], payload, nil
 		}
 	}
 
-	return false, "", ""
+	return false, "", "", nil, nil
 }
 
-func (s *Server) processInputFile(inputFile string, searchColumns []string) (*Metrics, error) {
+func (s *Server) getExact(key string) ([]byte, bool, error) {
+	if s.index != nil {
+		value, ok := s.index.get(key)
+		return value, ok, nil
+	}
+	value, err := s.db.Get([]byte(key))
+	if err == errNotFound {
+		return nil, false, nil
+	}
+	return value, err == nil, err
+}
+
+// scanBucket calls fn for each entry under prefix, in key order, until fn
+// returns false. value is only valid during the call. The scan stops with
+// ctx's error as soon as it notices ctx is done.
+func (s *Server) scanBucket(ctx context.Context, prefix string, fn func(key string, value []byte) bool) error {
+	if s.index != nil {
+		for i, entry := range s.index.bucket(prefix) {
+			if i%cancelCheckInterval == cancelCheckInterval-1 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+			if !fn(entry.key, entry.value) {
+				break
+			}
+		}
+		return nil
+	}
+
+	var ctxErr error
+	scanned := 0
+	err := s.db.Iterate([]byte(prefix), func(key, value []byte) bool {
+		if scanned%cancelCheckInterval == cancelCheckInterval-1 {
+			if ctxErr = ctx.Err(); ctxErr != nil {
+				return false
+			}
+		}
+		scanned++
+		return fn(string(key), value)
+	})
+	if ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+func normalizeLookupValue(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// lookupKey is the store key for a normalized value: its prefix bucket
+// followed by the value itself, so the key doubles as an exact-match key.
+func lookupKey(value string) string {
+	prefixLen := min(lookupPrefixLen, len(value))
+	return value[:prefixLen] + ":" + value
+}
+
+// processInputFile looks up every row of inputFile and writes the results,
+// returning the path it wrote them to.
+func (s *Server) processInputFile(ctx context.Context, inputFile string, opts ProcessOptions) (*Metrics, string, error) {
 	startTime := time.Now()
 	metrics := &Metrics{}
-	tmpFile := inputFile + ".tmp"
+	if opts.Resume && s.config.CheckpointRows <= 0 {
+		return metrics, "", errors.New("resuming needs checkpointing; set -checkpoint-rows")
+	}
 
 	inputData, err := os.ReadFile(inputFile)
 	if err != nil {
-		return metrics, err
+		return metrics, "", err
 	}
 
-	var records []Record
-	if err := csvutil.Unmarshal(inputData, &records); err != nil {
-		return metrics, err
+	text, inputFormat, err := readInputFile(inputData, opts.InputFormat)
+	if err != nil {
+		return metrics, "", err
+	}
+	// The output is compressed like the input, which may only be known now
+	// that it has been sniffed.
+	outputFile := outputPath(inputFile, opts.OutputFormat, inputFormat.Compression)
+	tmpFile := outputFile + ".tmp"
+	delimiter, _ := formatRune(inputFormat.Delimiter, "delimiter")
+	quote, _ := quoteRune(inputFormat.Quote)
+
+	reader := csv.NewReader(bytes.NewReader(text))
+	reader.Comma = delimiter
+
+	var rejects *rejectWriter
+	if opts.Lenient {
+		if rejects, err = newRejectWriter(inputFile, quote); err != nil {
+			return metrics, "", err
+		}
+		defer rejects.Close()
+	}
+	records, err := decodeInputRecords(reader, rejects, opts.MaxErrors)
+	if rejects != nil {
+		metrics.RejectedRecords = int64(rejects.count)
+		if closeErr := rejects.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return metrics, "", err
+	}
+	if quote != '"' {
+		for i := range records {
+			records[i].Name = swapQuotes(records[i].Name, quote)
+		}
 	}
 
-	sort.Slice(records, func(i, j int) bool {
+	// Checkpoints refer to rows by position, so the order has to be the same
+	// on every run.
+	sort.SliceStable(records, func(i, j int) bool {
 		return records[i].Name < records[j].Name
 	})
 
-	bar := progressbar.Default(int64(len(records)), "Processing Records")
+	s.dbMu.RLock()
+	payloadFields := s.meta.PayloadFields
+	dictionaryVersion := s.meta.Version
+	s.dbMu.RUnlock()
+
+	results := make([]Record, 0, len(records))
+	chunkSize := len(records)
+	var checkpoint *checkpointer
+	if s.config.CheckpointRows > 0 {
+		inputHash := sha256.Sum256(inputData)
+		var resumed []Record
+		checkpoint, resumed, err = openCheckpoint(outputFile, Checkpoint{
+			InputHash:         hex.EncodeToString(inputHash[:]),
+			DictionaryVersion: dictionaryVersion,
+			Config:            s.config,
+			Options:           opts,
+		}, opts.Resume)
+		if err != nil {
+			return metrics, "", err
+		}
+		defer checkpoint.Close()
+
+		if len(resumed) > len(records) {
+			return metrics, "", fmt.Errorf("checkpoint holds %d rows, input has %d", len(resumed), len(records))
+		}
+		for _, record := range resumed {
+			if record.Result {
+				metrics.MatchedRecords++
+			}
+		}
+		metrics.ProcessedRecords = int64(len(resumed))
+		metrics.ResumedRecords = int64(len(resumed))
+		results = append(results, resumed...)
+		chunkSize = s.config.CheckpointRows
+	}
+
+	bar := s.config.Progress.Start(ctx, "Processing Records", inputFile, int64(len(records)))
+	defer bar.Finish()
+	bar.Add(len(results))
+
+	for start := len(results); start < len(records); start += chunkSize {
+		end := min(start+chunkSize, len(records))
+		processed, err := s.lookupRecords(ctx, records[start:end], opts.Modes, metrics, bar)
+		if err != nil {
+			return metrics, "", err
+		}
+		results = append(results, processed...)
+		if checkpoint != nil {
+			if err := checkpoint.save(processed); err != nil {
+				return metrics, "", err
+			}
+		}
+	}
+
+	output, err := os.Create(tmpFile)
+	if err != nil {
+		return metrics, "", err
+	}
+	defer output.Close()
+
+	outputFormat, textual := outputFileFormat(opts.OutputFormat, inputFormat)
+	stream, err := newOutputStream(output, outputFormat, textual)
+	if err != nil {
+		return metrics, "", err
+	}
+
+	writer, err := newRecordWriter(opts.OutputFormat, stream, delimiter, payloadFields)
+	if err != nil {
+		return metrics, "", err
+	}
+
+	for _, record := range results {
+		if err := writer.Write(record); err != nil {
+			return metrics, "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return metrics, "", err
+	}
+	if err := stream.Close(); err != nil {
+		return metrics, "", err
+	}
+	if err := output.Close(); err != nil {
+		return metrics, "", err
+	}
+
+	metrics.ProcessingTime = time.Since(startTime)
+
+	if err := os.Rename(tmpFile, outputFile); err != nil {
+		return metrics, "", err
+	}
+	if checkpoint != nil {
+		if err := checkpoint.finish(); err != nil {
+			slog.WarnContext(ctx, "could not remove checkpoint", "output", outputFile, "error", err)
+		}
+	}
+
+	return metrics, outputFile, nil
+}
+
+// recordBatch is a slice of the input on its way through the worker pool.
+// seq is its position among the batches of one lookupRecords call.
+type recordBatch struct {
+	seq     int
+	records []Record
+}
+
+// lookupRecords looks up every record on the worker pool and returns the
+// processed copies in input order. Workers finish batches in any order; the
+// reassembly loop holds on to early ones until every batch before them has
+// arrived. It returns only after all workers have exited, with ctx's error if
+// it was cancelled first. The first lookup that fails stops the other
+// workers and its error is returned; no partial results are.
+func (s *Server) lookupRecords(ctx context.Context, records []Record, modes []string, metrics *Metrics, bar Progress) ([]Record, error) {
+	batchSize := s.config.BatchSize
+	if batchSize <= 0 {
+		batchSize = len(records)
+	}
+	workerCount := s.config.WorkerCount
+	if workerCount <= 0 {
+		workerCount = 1
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var failOnce sync.Once
+	var lookupErr error
+	fail := func(err error) {
+		failOnce.Do(func() {
+			lookupErr = err
+			cancel()
+		})
+	}
 
 	var wg sync.WaitGroup
-	recordsChan := make(chan []Record, s.config.BufferSize)
-	resultsChan := make(chan []Record, s.config.BufferSize)
+	recordsChan := make(chan recordBatch, s.config.BufferSize)
+	resultsChan := make(chan recordBatch, s.config.BufferSize)
 
-	for i := 0; i < s.config.WorkerCount; i++ {
+	for i := 0; i < workerCount; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+		batches:
 			for batch := range recordsChan {
-				processedBatch := make([]Record, len(batch))
-				copy(processedBatch, batch)
+				if ctx.Err() != nil {
+					continue
+				}
+				processedBatch := make([]Record, len(batch.records))
+				copy(processedBatch, batch.records)
 
 				for i := range processedBatch {
-					found, matchedValue, matchType := s.lookupWithCache(strings.ToLower(processedBatch[i].Name))
+					found, matchedValue, matchType, payload, err := s.lookupWithCache(ctx, strings.ToLower(processedBatch[i].Name), modes)
+					if err != nil {
+						fail(err)
+						continue batches
+					}
 					processedBatch[i].Result = found
 					processedBatch[i].MatchedValue = matchedValue
 					processedBatch[i].MatchType = matchType
+					processedBatch[i].Payload = payload
 
 					if found {
 						atomic.AddInt64(&metrics.MatchedRecords, 1)
@@ -222,86 +691,92 @@ func (s *Server) processInputFile(inputFile string, searchColumns []string) (*Me
 					atomic.AddInt64(&metrics.ProcessedRecords, 1)
 					bar.Add(1)
 				}
-				resultsChan <- processedBatch
+				resultsChan <- recordBatch{seq: batch.seq, records: processedBatch}
 			}
 		}()
 	}
 
 	go func() {
-		batchSize := s.config.BatchSize
+		defer close(recordsChan)
+		seq := 0
 		for i := 0; i < len(records); i += batchSize {
-			end := i + batchSize
-			if end > len(records) {
-				end = len(records)
+			end := min(i+batchSize, len(records))
+			select {
+			case recordsChan <- recordBatch{seq: seq, records: records[i:end]}:
+			case <-ctx.Done():
+				return
 			}
-			recordsChan <- records[i:end]
+			seq++
 		}
-		close(recordsChan)
 	}()
 
-	results := make([]Record, 0, len(records))
 	go func() {
-		for batch := range resultsChan {
-			results = append(results, batch...)
-		}
+		wg.Wait()
+		close(resultsChan)
 	}()
 
-	wg.Wait()
-	close(resultsChan)
-
-	output, err := os.Create(tmpFile)
-	if err != nil {
-		return metrics, err
-	}
-	defer output.Close()
-
-	writer := csv.NewWriter(output)
-	defer writer.Flush()
-
-	if err := writer.Write([]string{"name", "lookup_result", "matched_value", "match_type"}); err != nil {
-		return metrics, err
-	}
-
-	for _, record := range results {
-		if err := writer.Write([]string{
-			record.Name,
-			strconv.FormatBool(record.Result),
-			record.MatchedValue,
-			record.MatchType,
-		}); err != nil {
-			return metrics, err
+	results := make([]Record, 0, len(records))
+	pending := make(map[int][]Record)
+	next := 0
+	for batch := range resultsChan {
+		pending[batch.seq] = batch.records
+		for {
+			ready, ok := pending[next]
+			if !ok {
+				break
+			}
+			results = append(results, ready...)
+			delete(pending, next)
+			next++
 		}
 	}
-
-	metrics.ProcessingTime = time.Since(startTime)
-
-	if err := os.Rename(tmpFile, inputFile); err != nil {
-		return metrics, err
+	if err := parent.Err(); err != nil {
+		return nil, err
 	}
-
-	return metrics, nil
+	if lookupErr != nil {
+		return nil, lookupErr
+	}
+	return results, nil
 }
 
 func (s *Server) handleStringLookup(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	var req StringLookupRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	modes, err := s.requestModes(req.Modes)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel, err := s.requestContext(r, req.Timeout)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
+	defer cancel()
 
 	cacheHitsBefore := atomic.LoadUint64(&s.cacheStats.hits)
-	found, matchedValue, matchType := s.lookupWithCache(strings.ToLower(req.SearchString))
+	found, matchedValue, matchType, payload, err := s.lookupWithCache(ctx, strings.ToLower(req.SearchString), modes)
+	if err != nil {
+		httpError(w, r, err.Error(), errorStatus(err))
+		return
+	}
 	cacheHit := atomic.LoadUint64(&s.cacheStats.hits) > cacheHitsBefore
 
 	response := StringLookupResponse{
 		Found:        found,
 		MatchedValue: matchedValue,
 		MatchType:    matchType,
+		Payload:      payload,
 		CacheHit:     cacheHit,
 	}
 
@@ -311,25 +786,65 @@ func (s *Server) handleStringLookup(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleFileProcess(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	var req FileProcessRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	metrics, err := s.processInputFile(req.InputFilePath, req.SearchColumns)
+	modes, err := s.requestModes(req.Modes)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.OutputFormat == "" {
+		req.OutputFormat = s.config.OutputFormat
+	}
+	outputFormat, err := parseOutputFormat(req.OutputFormat)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	inputFormat := s.config.InputFormat
+	if req.InputFormat != nil {
+		inputFormat = *req.InputFormat
+	}
+
+	maxErrors := req.MaxErrors
+	if maxErrors == 0 {
+		maxErrors = s.config.MaxErrors
+	}
+
+	ctx, cancel, err := s.requestContext(r, req.Timeout)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer cancel()
+
+	metrics, processedPath, err := s.processInputFile(ctx, req.InputFilePath, ProcessOptions{
+		SearchColumns: req.SearchColumns,
+		Modes:         modes,
+		OutputFormat:  outputFormat,
+		InputFormat:   inputFormat,
+		Lenient:       req.Lenient || s.config.Lenient,
+		MaxErrors:     maxErrors,
+		Resume:        req.Resume,
+	})
+	if err != nil {
+		httpError(w, r, err.Error(), errorStatus(err))
 		return
 	}
 
 	response := FileProcessResponse{
 		Metrics:       metrics,
-		ProcessedPath: req.InputFilePath,
+		ProcessedPath: processedPath,
 		CacheStats: struct {
 			Hits   uint64 `json:"cache_hits"`
 			Misses uint64 `json:"cache_misses"`
@@ -339,10 +854,55 @@ func (s *Server) handleFileProcess(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
+	if metrics.RejectedRecords > 0 {
+		response.RejectsPath = rejectsPath(req.InputFilePath)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// requestContext derives the context a request's work runs under. It ends
+// when the client goes away or, if set, after the request's timeout or else
+// the configured RequestTimeout.
+func (s *Server) requestContext(r *http.Request, timeout string) (context.Context, context.CancelFunc, error) {
+	limit := s.config.RequestTimeout
+	if timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid timeout %q: %w", timeout, err)
+		}
+		limit = d
+	}
+	if limit <= 0 {
+		ctx, cancel := context.WithCancel(r.Context())
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), limit)
+	return ctx, cancel, nil
+}
+
+// errorStatus maps an error from a lookup or file run to an HTTP status.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled), errors.Is(err, errStoreUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// requestModes parses the modes given on a request, falling back to the
+// server's configured modes.
+func (s *Server) requestModes(modes []string) ([]string, error) {
+	if len(modes) == 0 {
+		modes = s.config.Modes
+	}
+	return parseModes(modes)
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -356,31 +916,126 @@ func main() {
 	workers := flag.Int("workers", 4, "Number of workers")
 	batchSize := flag.Int("batch", 1000, "Batch size")
 	bufferSize := flag.Int("buffer", 100, "Buffer size")
+	checkpointRows := flag.Int("checkpoint-rows", 0, "Rows between processing checkpoints for long batch runs; 0 (the default) disables checkpointing")
+	lenient := flag.Bool("lenient", false, "Skip malformed input rows, writing them to a .rejects.csv sidecar")
+	maxErrors := flag.Int("max-errors", 0, "Abort a lenient run after this many malformed rows; 0 means no limit")
+	requestTimeout := flag.Duration("request-timeout", 0, "Default deadline for API requests; 0 means none")
+	progressFlag := flag.String("progress", progressAuto, "Progress reporting: auto, terminal, log or none (auto logs in server mode)")
+	logLevel := flag.String("log-level", "info", "Log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	resume := flag.Bool("resume", false, "Continue processing from the checkpoint of an interrupted run")
 	port := flag.String("port", "", "Port for API server")
+	backend := flag.String("backend", "leveldb", "Storage backend: leveldb, memory or bolt")
+	dbPath := flag.String("db", "lookup.db", "Store location for the leveldb or bolt backend")
+	snapshot := flag.String("snapshot", "", "Start from a snapshot file instead of a lookup file")
+	exportPath := flag.String("export-snapshot", "", "Write the lookup store to a snapshot file and exit")
+	importPath := flag.String("import-snapshot", "", "Import a snapshot file into a new -db directory and exit")
+	memoryIndex := flag.Bool("memory-index", false, "Serve lookups from an in-process index instead of the store")
+	lookupFormat := flag.String("lookup-format", "", "Lookup file format: text, csv or jsonl (default: from extension)")
+	lookupKey := flag.String("lookup-key", "name", "Key column for csv and jsonl lookup files")
+	outputFormatFlag := flag.String("output-format", "csv", "Output format: csv, excel-csv, jsonl or parquet")
+	delimiter := flag.String("delimiter", "", `Input delimiter, e.g. "," "|" or "\t" (default: sniffed)`)
+	quote := flag.String("quote", "", `Input quote character (default: ")`)
+	encoding := flag.String("encoding", "", "Input encoding: utf-8, utf-16, utf-16le, utf-16be or latin-1 (default: sniffed)")
+	compression := flag.String("compression", "", "Input compression: none, gzip or zstd (default: sniffed)")
+	modesFlag := flag.String("modes", "exact,contains,reverse-contains", "Comma-separated match modes in priority order: exact, prefix, contains, reverse-contains, fuzzy, phonetic")
 	flag.Parse()
 
+	logger, err := newLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+
+	modes, err := parseModes(strings.Split(*modesFlag, ","))
+	if err != nil {
+		log.Fatal(err)
+	}
+	outputFormat, err := parseOutputFormat(*outputFormatFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	config := Config{
-		WorkerCount: *workers,
-		BatchSize:   *batchSize,
-		BufferSize:  *bufferSize,
+		WorkerCount:    *workers,
+		BatchSize:      *batchSize,
+		BufferSize:     *bufferSize,
+		CheckpointRows: *checkpointRows,
+		Backend:        *backend,
+		DBPath:         *dbPath,
+		SnapshotPath:   *snapshot,
+		MemoryIndex:    *memoryIndex,
+		LookupFormat:   *lookupFormat,
+		LookupKey:      *lookupKey,
+		Modes:          modes,
+		OutputFormat:   outputFormat,
+		InputFormat: FileFormat{
+			Delimiter:   *delimiter,
+			Quote:       *quote,
+			Encoding:    *encoding,
+			Compression: *compression,
+		},
+		Lenient:        *lenient,
+		MaxErrors:      *maxErrors,
+		RequestTimeout: *requestTimeout,
 	}
 
-	if *port != "" {
-		server, err := NewServer(*lookupFile, config)
+	progress, err := newProgressReporter(*progressFlag, *port != "", logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.Progress = progress
+
+	if *importPath != "" {
+		header, err := importSnapshot(*importPath, *backend, *dbPath)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer server.db.Close()
+		fmt.Printf("Imported %d entries (version %s) into %s\n", header.Meta.Entries, header.Meta.Version, *dbPath)
+		return
+	}
 
-		http.HandleFunc("/lookup", server.handleStringLookup)
-		http.HandleFunc("/process-file", server.handleFileProcess)
+	if *exportPath != "" {
+		server, err := NewServer(*lookupFile, config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer server.Close()
 
-		log.Printf("Server starting on port %s", *port)
-		log.Fatal(http.ListenAndServe(":"+*port, nil))
+		header, err := exportSnapshot(server.db, *exportPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Exported %d entries (version %s) to %s\n", header.Meta.Entries, header.Meta.Version, *exportPath)
 		return
 	}
 
-	if *inputFile == "" || *lookupFile == "" {
+	if *port != "" {
+		jobs := newJobTracker(progress)
+		config.Progress = jobs
+
+		server, err := NewServer(*lookupFile, config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer server.Close()
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/lookup", server.handleStringLookup)
+		mux.HandleFunc("/process-file", server.handleFileProcess)
+		mux.HandleFunc("/version", server.handleVersion)
+		mux.HandleFunc("/admin/reload", server.handleReload)
+		mux.HandleFunc("/jobs", jobs.handleJobs)
+		go server.reloadOnSignal()
+
+		logger.Info("server starting", "port", *port, "dictionary_version", server.version().Version)
+		err = http.ListenAndServe(":"+*port, accessLog(mux))
+		logger.Error("server stopped", "error", err)
+		server.Close()
+		os.Exit(1)
+	}
+
+	if *inputFile == "" || (*lookupFile == "" && *snapshot == "") {
 		flag.Usage()
 		return
 	}
@@ -389,9 +1044,22 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer server.db.Close()
-
-	metrics, err := server.processInputFile(*inputFile, []string{"name"})
+	defer server.Close()
+
+	// Ctrl-C stops the run at the next record; with -checkpoint-rows,
+	// completed chunks stay in the checkpoint for -resume.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	metrics, _, err := server.processInputFile(ctx, *inputFile, ProcessOptions{
+		SearchColumns: []string{"name"},
+		Modes:         modes,
+		OutputFormat:  outputFormat,
+		InputFormat:   config.InputFormat,
+		Lenient:       config.Lenient,
+		MaxErrors:     config.MaxErrors,
+		Resume:        *resume,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -399,6 +1067,12 @@ func main() {
 	fmt.Printf("Processing completed:\n")
 	fmt.Printf("Total records processed: %d\n", metrics.ProcessedRecords)
 	fmt.Printf("Matched records: %d\n", metrics.MatchedRecords)
+	if metrics.RejectedRecords > 0 {
+		fmt.Printf("Rejected records: %d (see %s)\n", metrics.RejectedRecords, rejectsPath(*inputFile))
+	}
+	if metrics.ResumedRecords > 0 {
+		fmt.Printf("Resumed records: %d\n", metrics.ResumedRecords)
+	}
 	fmt.Printf("Processing time: %v\n", metrics.ProcessingTime)
 	fmt.Printf("Cache hits: %d\n", atomic.LoadUint64(&server.cacheStats.hits))
 	fmt.Printf("Cache misses: %d\n", atomic.LoadUint64(&server.cacheStats.misses))
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var (
+	errNotFound         = errors.New("key not found")
+	errStoreUnavailable = errors.New("lookup store unavailable")
+)
+
+// Backend is the key/value store behind a Server. Keys are kept in byte
+// order so lookups can walk a prefix bucket.
+type Backend interface {
+	// Get returns errNotFound if key is absent.
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	// Iterate calls fn for each key starting with prefix, in key order, until
+	// fn returns false. key and value are only valid during the call.
+	Iterate(prefix []byte, fn func(key, value []byte) bool) error
+	Write(batch *WriteBatch) error
+	Close() error
+}
+
+type batchOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// WriteBatch collects puts and deletes that a Backend applies together.
+type WriteBatch struct {
+	ops []batchOp
+}
+
+func (b *WriteBatch) Put(key, value []byte) {
+	b.ops = append(b.ops, batchOp{
+		key:   append([]byte(nil), key...),
+		value: append([]byte(nil), value...),
+	})
+}
+
+func (b *WriteBatch) Delete(key []byte) {
+	b.ops = append(b.ops, batchOp{key: append([]byte(nil), key...), delete: true})
+}
+
+func (b *WriteBatch) Len() int {
+	return len(b.ops)
+}
+
+func (b *WriteBatch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// openBackend opens the named backend at path. The memory backend ignores
+// path and starts empty.
+func openBackend(kind, path string) (Backend, error) {
+	switch kind {
+	case "", "leveldb":
+		return openLevelDBBackend(path)
+	case "memory":
+		return newMemoryBackend(), nil
+	case "bolt":
+		return openBoltBackend(path)
+	default:
+		return nil, fmt.Errorf("unknown backend %q", kind)
+	}
+}
+
+type levelDBBackend struct {
+	db *leveldb.DB
+}
+
+func openLevelDBBackend(path string) (*levelDBBackend, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &levelDBBackend{db: db}, nil
+}
+
+func (b *levelDBBackend) Get(key []byte) ([]byte, error) {
+	value, err := b.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, errNotFound
+	}
+	return value, err
+}
+
+func (b *levelDBBackend) Put(key, value []byte) error {
+	return b.db.Put(key, value, nil)
+}
+
+func (b *levelDBBackend) Delete(key []byte) error {
+	return b.db.Delete(key, nil)
+}
+
+func (b *levelDBBackend) Iterate(prefix []byte, fn func(key, value []byte) bool) error {
+	iter := b.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		if !fn(iter.Key(), iter.Value()) {
+			break
+		}
+	}
+	return iter.Error()
+}
+
+func (b *levelDBBackend) Write(batch *WriteBatch) error {
+	lb := new(leveldb.Batch)
+	for _, op := range batch.ops {
+		if op.delete {
+			lb.Delete(op.key)
+		} else {
+			lb.Put(op.key, op.value)
+		}
+	}
+	return b.db.Write(lb, nil)
+}
+
+func (b *levelDBBackend) Close() error {
+	return b.db.Close()
+}
+
+// memoryBackend keeps everything in a map and sorts the key set lazily, the
+// first time it is iterated after a write. Lookup lists are loaded in bulk and
+// then only read, so the sort happens once per load.
+type memoryBackend struct {
+	mu     sync.RWMutex
+	values map[string][]byte
+	keys   []string
+	sorted bool
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{values: make(map[string][]byte), sorted: true}
+}
+
+func (b *memoryBackend) Get(key []byte) ([]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	value, ok := b.values[string(key)]
+	if !ok {
+		return nil, errNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (b *memoryBackend) Put(key, value []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.put(string(key), append([]byte(nil), value...))
+	return nil
+}
+
+func (b *memoryBackend) Delete(key []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.delete(string(key))
+	return nil
+}
+
+func (b *memoryBackend) put(key string, value []byte) {
+	if _, exists := b.values[key]; !exists {
+		b.keys = append(b.keys, key)
+		b.sorted = false
+	}
+	b.values[key] = value
+}
+
+func (b *memoryBackend) delete(key string) {
+	if _, exists := b.values[key]; !exists {
+		return
+	}
+	delete(b.values, key)
+	b.ensureSorted()
+	i := sort.SearchStrings(b.keys, key)
+	b.keys = append(b.keys[:i], b.keys[i+1:]...)
+}
+
+func (b *memoryBackend) ensureSorted() {
+	if !b.sorted {
+		sort.Strings(b.keys)
+		b.sorted = true
+	}
+}
+
+func (b *memoryBackend) Iterate(prefix []byte, fn func(key, value []byte) bool) error {
+	b.mu.RLock()
+	for !b.sorted {
+		b.mu.RUnlock()
+		b.mu.Lock()
+		b.ensureSorted()
+		b.mu.Unlock()
+		b.mu.RLock()
+	}
+	defer b.mu.RUnlock()
+
+	p := string(prefix)
+	for i := sort.SearchStrings(b.keys, p); i < len(b.keys); i++ {
+		key := b.keys[i]
+		if !strings.HasPrefix(key, p) {
+			break
+		}
+		if !fn([]byte(key), b.values[key]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (b *memoryBackend) Write(batch *WriteBatch) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, op := range batch.ops {
+		if op.delete {
+			b.delete(string(op.key))
+		} else {
+			b.put(string(op.key), op.value)
+		}
+	}
+	return nil
+}
+
+func (b *memoryBackend) Close() error {
+	return nil
+}
+
+// unavailableBackend stands in for a store that could not be reopened, so
+// lookups fail with errStoreUnavailable instead of touching a closed handle.
+type unavailableBackend struct {
+	cause error
+}
+
+func (b unavailableBackend) err() error {
+	return fmt.Errorf("%w: %v", errStoreUnavailable, b.cause)
+}
+
+func (b unavailableBackend) Get([]byte) ([]byte, error) { return nil, b.err() }
+func (b unavailableBackend) Put(_, _ []byte) error      { return b.err() }
+func (b unavailableBackend) Delete([]byte) error        { return b.err() }
+func (b unavailableBackend) Write(*WriteBatch) error    { return b.err() }
+func (b unavailableBackend) Close() error               { return nil }
+
+func (b unavailableBackend) Iterate([]byte, func(key, value []byte) bool) error {
+	return b.err()
+}
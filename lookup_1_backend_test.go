@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// backendCase opens a fresh store of one backend kind for the conformance
+// tests. persistent backends are also reopened to check that data survives.
+type backendCase struct {
+	kind       string
+	persistent bool
+}
+
+var backendCases = []backendCase{
+	{kind: "leveldb", persistent: true},
+	{kind: "bolt", persistent: true},
+	{kind: "memory"},
+}
+
+// forEachBackend runs test against a new, empty store of every backend.
+func forEachBackend(t *testing.T, test func(t *testing.T, c backendCase, path string, db Backend)) {
+	for _, c := range backendCases {
+		t.Run(c.kind, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "store")
+			db, err := openBackend(c.kind, path)
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			t.Cleanup(func() { db.Close() })
+			test(t, c, path, db)
+		})
+	}
+}
+
+func mustPut(t *testing.T, db Backend, key, value string) {
+	t.Helper()
+	if err := db.Put([]byte(key), []byte(value)); err != nil {
+		t.Fatalf("put %q: %v", key, err)
+	}
+}
+
+// collect returns the keys and values Iterate yields under prefix, stopping
+// after limit entries when limit is positive.
+func collect(t *testing.T, db Backend, prefix string, limit int) []string {
+	t.Helper()
+	var got []string
+	err := db.Iterate([]byte(prefix), func(key, value []byte) bool {
+		got = append(got, string(key)+"="+string(value))
+		return limit <= 0 || len(got) < limit
+	})
+	if err != nil {
+		t.Fatalf("iterate %q: %v", prefix, err)
+	}
+	return got
+}
+
+func TestBackendGetPutDelete(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, _ backendCase, _ string, db Backend) {
+		if _, err := db.Get([]byte("missing")); err != errNotFound {
+			t.Fatalf("get missing: got %v, want errNotFound", err)
+		}
+
+		mustPut(t, db, "abc:abcd", "1")
+		mustPut(t, db, "abc:abcd", "2")
+		value, err := db.Get([]byte("abc:abcd"))
+		if err != nil || string(value) != "2" {
+			t.Fatalf("get after overwrite: got %q, %v", value, err)
+		}
+
+		// The returned value belongs to the caller.
+		value[0] = 'x'
+		if value, _ := db.Get([]byte("abc:abcd")); string(value) != "2" {
+			t.Fatalf("stored value changed through a returned slice: %q", value)
+		}
+
+		if err := db.Delete([]byte("abc:abcd")); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+		if _, err := db.Get([]byte("abc:abcd")); err != errNotFound {
+			t.Fatalf("get deleted: got %v, want errNotFound", err)
+		}
+		if err := db.Delete([]byte("abc:abcd")); err != nil {
+			t.Fatalf("delete missing: %v", err)
+		}
+	})
+}
+
+func TestBackendPutCopiesArguments(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, _ backendCase, _ string, db Backend) {
+		key, value := []byte("abc:abc"), []byte("v")
+		if err := db.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+		key[0], value[0] = 'x', 'x'
+		if got, err := db.Get([]byte("abc:abc")); err != nil || string(got) != "v" {
+			t.Fatalf("got %q, %v after reusing the put slices", got, err)
+		}
+	})
+}
+
+func TestBackendIterateOrderAndPrefix(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, _ backendCase, _ string, db Backend) {
+		// Written out of order so the memory backend has to sort.
+		for _, key := range []string{"bob:bobby", "ali:alice", "bob:bob", "al:al", "ali:ali", "bo:bo"} {
+			mustPut(t, db, key, key[:1])
+		}
+
+		all := collect(t, db, "", 0)
+		want := []string{"al:al=a", "ali:ali=a", "ali:alice=a", "bo:bo=b", "bob:bob=b", "bob:bobby=b"}
+		if !reflect.DeepEqual(all, want) {
+			t.Fatalf("iterate all:\n got %q\nwant %q", all, want)
+		}
+
+		tests := []struct {
+			prefix string
+			want   []string
+		}{
+			{"ali:", []string{"ali:ali=a", "ali:alice=a"}},
+			{"bob:", []string{"bob:bob=b", "bob:bobby=b"}},
+			{"al", []string{"al:al=a", "ali:ali=a", "ali:alice=a"}},
+			{"bob:bobby", []string{"bob:bobby=b"}},
+			{"bob:bobbyx", nil},
+			{"c", nil},
+			{"a", []string{"al:al=a", "ali:ali=a", "ali:alice=a"}},
+		}
+		for _, tt := range tests {
+			if got := collect(t, db, tt.prefix, 0); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("iterate %q:\n got %q\nwant %q", tt.prefix, got, tt.want)
+			}
+		}
+	})
+}
+
+func TestBackendIterateStopsEarly(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, _ backendCase, _ string, db Backend) {
+		for i := 0; i < 10; i++ {
+			mustPut(t, db, fmt.Sprintf("abc:abc%d", i), "v")
+		}
+		got := collect(t, db, "abc:", 3)
+		want := []string{"abc:abc0=v", "abc:abc1=v", "abc:abc2=v"}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+}
+
+// Writes after an iteration must show up in the next one, in order; the
+// memory backend only re-sorts lazily.
+func TestBackendIterateAfterWrites(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, _ backendCase, _ string, db Backend) {
+		mustPut(t, db, "abc:abc", "1")
+		mustPut(t, db, "abc:abe", "1")
+		collect(t, db, "", 0)
+
+		mustPut(t, db, "abc:abd", "2")
+		mustPut(t, db, "abb:abb", "2")
+		if err := db.Delete([]byte("abc:abe")); err != nil {
+			t.Fatal(err)
+		}
+		got := collect(t, db, "", 0)
+		want := []string{"abb:abb=2", "abc:abc=1", "abc:abd=2"}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestBackendWriteBatch(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, _ backendCase, _ string, db Backend) {
+		mustPut(t, db, "old:old", "x")
+
+		batch := new(WriteBatch)
+		batch.Put([]byte("zed:zed"), []byte("1"))
+		batch.Put([]byte("abc:abc"), []byte("1"))
+		batch.Delete([]byte("old:old"))
+		batch.Put([]byte("abc:abc"), []byte("2")) // later ops win
+		batch.Put([]byte("tmp:tmp"), []byte("1"))
+		batch.Delete([]byte("tmp:tmp"))
+		batch.Delete([]byte("missing"))
+		if err := db.Write(batch); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+
+		got := collect(t, db, "", 0)
+		want := []string{"abc:abc=2", "zed:zed=1"}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+
+		// A reset batch can be reused without replaying its old ops.
+		batch.Reset()
+		if batch.Len() != 0 {
+			t.Fatalf("reset batch has %d ops", batch.Len())
+		}
+		batch.Put([]byte("new:new"), []byte("3"))
+		if err := db.Write(batch); err != nil {
+			t.Fatal(err)
+		}
+		got = collect(t, db, "", 0)
+		want = []string{"abc:abc=2", "new:new=3", "zed:zed=1"}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("after reuse got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestBackendReopen(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, c backendCase, path string, db Backend) {
+		if !c.persistent {
+			t.Skip("backend keeps nothing across opens")
+		}
+		mustPut(t, db, "abc:abc", "1")
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		db, err := openBackend(c.kind, path)
+		if err != nil {
+			t.Fatalf("reopen: %v", err)
+		}
+		defer db.Close()
+		if got := collect(t, db, "", 0); !reflect.DeepEqual(got, []string{"abc:abc=1"}) {
+			t.Fatalf("after reopen got %q", got)
+		}
+	})
+}
+
+func TestUnavailableBackend(t *testing.T) {
+	db := unavailableBackend{cause: fmt.Errorf("disk gone")}
+	if _, err := db.Get([]byte("k")); err == nil {
+		t.Fatal("get succeeded on an unavailable backend")
+	}
+	if err := db.Iterate(nil, func(_, _ []byte) bool { return true }); err == nil {
+		t.Fatal("iterate succeeded on an unavailable backend")
+	}
+	if status := errorStatus(db.Write(new(WriteBatch))); status != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, want %d", status, http.StatusServiceUnavailable)
+	}
+}
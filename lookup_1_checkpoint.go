@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Checkpoint records how far processInputFile got through the sorted input.
+// Rows processed records are stored as JSON lines in the partial file, whose
+// first PartialSize bytes are known to be complete.
+type Checkpoint struct {
+	InputHash         string         `json:"input_hash"`
+	DictionaryVersion string         `json:"dictionary_version"`
+	Config            Config         `json:"config"`
+	Options           ProcessOptions `json:"options"`
+	Rows              int            `json:"rows"`
+	PartialSize       int64          `json:"partial_size"`
+}
+
+// checkpointer appends processed records to the partial file and rewrites the
+// checkpoint after each chunk. Both files are left behind when a run fails so
+// that it can be resumed, and removed once the output is in place.
+type checkpointer struct {
+	path        string
+	partialPath string
+	partial     *os.File
+	state       Checkpoint
+}
+
+func checkpointPaths(outputFile string) (string, string) {
+	return outputFile + ".checkpoint", outputFile + ".partial"
+}
+
+// openCheckpoint starts checkpointing a run described by state. With resume
+// set and a checkpoint left by an earlier run of the same input, config and
+// dictionary, it returns the records that run already processed; otherwise
+// any old checkpoint is discarded and the run starts from the first row.
+func openCheckpoint(outputFile string, state Checkpoint, resume bool) (*checkpointer, []Record, error) {
+	path, partialPath := checkpointPaths(outputFile)
+	c := &checkpointer{path: path, partialPath: partialPath, state: state}
+
+	if resume {
+		saved, err := readCheckpoint(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+		if err == nil {
+			if err := saved.matches(state); err != nil {
+				return nil, nil, fmt.Errorf("cannot resume from %s: %w", path, err)
+			}
+			records, err := c.restore(saved)
+			if err != nil {
+				return nil, nil, err
+			}
+			return c, records, nil
+		}
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+	partial, err := os.Create(partialPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.partial = partial
+	return c, nil, nil
+}
+
+func readCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var saved Checkpoint
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("reading checkpoint %s: %w", path, err)
+	}
+	return &saved, nil
+}
+
+// matches reports why a saved checkpoint cannot be resumed by current.
+// WorkerCount, BatchSize, BufferSize, CheckpointRows and RequestTimeout only
+// affect how the work is scheduled, so they may differ between runs.
+func (saved *Checkpoint) matches(current Checkpoint) error {
+	if saved.InputHash != current.InputHash {
+		return errors.New("input file has changed")
+	}
+	if saved.DictionaryVersion != current.DictionaryVersion {
+		return fmt.Errorf("dictionary version %s does not match checkpoint version %s", current.DictionaryVersion, saved.DictionaryVersion)
+	}
+
+	savedSettings, err := json.Marshal([]interface{}{schedulingFree(saved.Config), saved.Options})
+	if err != nil {
+		return err
+	}
+	currentSettings, err := json.Marshal([]interface{}{schedulingFree(current.Config), current.Options})
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(savedSettings, currentSettings) {
+		return errors.New("config has changed")
+	}
+	return nil
+}
+
+func schedulingFree(config Config) Config {
+	config.WorkerCount = 0
+	config.BatchSize = 0
+	config.BufferSize = 0
+	config.CheckpointRows = 0
+	config.RequestTimeout = 0
+	return config
+}
+
+// restore reopens the partial file, drops anything written after the last
+// checkpoint and reads back the records it holds.
+func (c *checkpointer) restore(saved *Checkpoint) ([]Record, error) {
+	partial, err := os.OpenFile(c.partialPath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := partial.Truncate(saved.PartialSize); err != nil {
+		partial.Close()
+		return nil, err
+	}
+
+	records := make([]Record, 0, saved.Rows)
+	decoder := json.NewDecoder(bufio.NewReader(partial))
+	for {
+		var record Record
+		if err := decoder.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			partial.Close()
+			return nil, fmt.Errorf("reading %s: %w", c.partialPath, err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != saved.Rows {
+		partial.Close()
+		return nil, fmt.Errorf("%s holds %d records, checkpoint expects %d", c.partialPath, len(records), saved.Rows)
+	}
+
+	if _, err := partial.Seek(saved.PartialSize, io.SeekStart); err != nil {
+		partial.Close()
+		return nil, err
+	}
+	c.partial = partial
+	c.state.Rows = saved.Rows
+	c.state.PartialSize = saved.PartialSize
+	return records, nil
+}
+
+// save appends records to the partial file and, once they are on disk,
+// advances the checkpoint past them.
+func (c *checkpointer) save(records []Record) error {
+	writer := bufio.NewWriter(c.partial)
+	encoder := json.NewEncoder(writer)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := c.partial.Sync(); err != nil {
+		return err
+	}
+	size, err := c.partial.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	c.state.Rows += len(records)
+	c.state.PartialSize = size
+	data, err := json.Marshal(c.state)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := c.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, c.path)
+}
+
+// Close closes the partial file, keeping the checkpoint for a later resume.
+func (c *checkpointer) Close() error {
+	return c.partial.Close()
+}
+
+// finish removes the checkpoint once the run's output has been written.
+func (c *checkpointer) finish() error {
+	c.partial.Close()
+	if err := os.Remove(c.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.Remove(c.partialPath)
+}
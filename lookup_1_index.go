@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+type indexEntry struct {
+	key   string
+	value []byte
+}
+
+// lookupIndex is an in-process copy of a store's lookup entries, kept as one
+// slice sorted by "prefix:value" key. A prefix bucket is a contiguous range
+// found by binary search, so lookups against it never touch the backend.
+type lookupIndex struct {
+	entries []indexEntry
+}
+
+func buildLookupIndex(db Backend) (*lookupIndex, error) {
+	idx := &lookupIndex{}
+	err := db.Iterate(nil, func(key, value []byte) bool {
+		if !isMetaKey(key) {
+			idx.entries = append(idx.entries, indexEntry{
+				key:   string(key),
+				value: append([]byte(nil), value...),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Backends iterate in key order already; sort anyway so the index does
+	// not depend on that.
+	sort.SliceStable(idx.entries, func(i, j int) bool {
+		return idx.entries[i].key < idx.entries[j].key
+	})
+	return idx, nil
+}
+
+// bucket returns the entries whose key starts with prefix, in key order.
+func (idx *lookupIndex) bucket(prefix string) []indexEntry {
+	start := sort.Search(len(idx.entries), func(i int) bool {
+		return idx.entries[i].key >= prefix
+	})
+	rest := idx.entries[start:]
+	end := sort.Search(len(rest), func(i int) bool {
+		return !strings.HasPrefix(rest[i].key, prefix)
+	})
+	return rest[:end]
+}
+
+func (idx *lookupIndex) get(key string) ([]byte, bool) {
+	i := sort.Search(len(idx.entries), func(i int) bool {
+		return idx.entries[i].key >= key
+	})
+	if i < len(idx.entries) && idx.entries[i].key == key {
+		return idx.entries[i].value, true
+	}
+	return nil, false
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// benchEntries is how many lookup values the index benchmarks load: enough
+// that LevelDB spreads them over several tables.
+const benchEntries = 200000
+
+// benchValue is the i-th lookup value. Every value shares one of 1000
+// three-letter prefixes, so each prefix bucket holds benchEntries/1000
+// entries.
+func benchValue(i int) string {
+	p := i % 1000
+	return fmt.Sprintf("%c%c%c-%06d", 'a'+p/100, 'a'+p/10%10, 'a'+p%10, i)
+}
+
+// benchStore loads benchEntries values into a LevelDB store once and
+// returns its path. Servers reopen it without a lookup file.
+func benchStore(b *testing.B) string {
+	b.Helper()
+	dir := b.TempDir()
+	lookupFile := filepath.Join(dir, "lookup.txt")
+	var data []byte
+	for i := 0; i < benchEntries; i++ {
+		data = append(data, benchValue(i)+"\n"...)
+	}
+	if err := os.WriteFile(lookupFile, data, 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	dbPath := filepath.Join(dir, "bench.db")
+	server, err := NewServer(lookupFile, Config{Backend: "leveldb", DBPath: dbPath, Progress: noopReporter{}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := server.Close(); err != nil {
+		b.Fatal(err)
+	}
+	return dbPath
+}
+
+// BenchmarkPerformLookup runs performLookup against the same LevelDB store
+// with the in-memory index off and on. Exact lookups search values that
+// are there; prefix and contains search ones that match nothing, so each
+// walks a whole prefix bucket as a miss has to. The match cache is not
+// involved.
+func BenchmarkPerformLookup(b *testing.B) {
+	dbPath := benchStore(b)
+	searches := map[string][]string{}
+	for i := 0; i < 1000; i++ {
+		value := benchValue(i * 131 % benchEntries)
+		searches[modeExact] = append(searches[modeExact], value)
+		searches[modePrefix] = append(searches[modePrefix], value[:4]+"x")
+		searches[modeContains] = append(searches[modeContains], value[:4]+"x")
+	}
+
+	for _, memoryIndex := range []bool{false, true} {
+		server, err := NewServer("", Config{Backend: "leveldb", DBPath: dbPath, MemoryIndex: memoryIndex, Progress: noopReporter{}})
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, mode := range []string{modeExact, modePrefix, modeContains} {
+			b.Run(fmt.Sprintf("%s/memory-index=%v", mode, memoryIndex), func(b *testing.B) {
+				values := searches[mode]
+				want := mode == modeExact
+				for i := 0; i < b.N; i++ {
+					found, _, _, _, err := server.performLookup(context.Background(), values[i%len(values)], []string{mode})
+					if err != nil {
+						b.Fatal(err)
+					}
+					if found != want {
+						b.Fatalf("%s %q: found %v, want %v", mode, values[i%len(values)], found, want)
+					}
+				}
+			})
+		}
+		if err := server.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// FileFormat describes how a delimited file is laid out on disk. Empty fields
+// are sniffed from the input by readInputFile; the resolved values are
+// then used for CSV output as well, so a file comes back the way it went in.
+type FileFormat struct {
+	Delimiter   string `json:"delimiter"`
+	Quote       string `json:"quote"`
+	Encoding    string `json:"encoding"`
+	Compression string `json:"compression"`
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	utf8BOM   = []byte{0xef, 0xbb, 0xbf}
+	utf16LE   = []byte{0xff, 0xfe}
+	utf16BE   = []byte{0xfe, 0xff}
+)
+
+var sniffDelimiters = []rune{',', '\t', '|', ';'}
+
+// readInputFile decompresses and decodes raw into UTF-8 text with any quote
+// character swapped for '"', filling in whatever format leaves unset.
+func readInputFile(raw []byte, format FileFormat) ([]byte, FileFormat, error) {
+	var err error
+	if format.Compression == "" {
+		format.Compression = sniffCompression(raw)
+	}
+	if raw, err = decompress(raw, format.Compression); err != nil {
+		return nil, format, err
+	}
+
+	if format.Encoding == "" {
+		format.Encoding = sniffEncoding(raw)
+	}
+	enc, err := textEncoding(format.Encoding)
+	if err != nil {
+		return nil, format, err
+	}
+	text, _, err := transform.Bytes(unicode.BOMOverride(enc.NewDecoder()), raw)
+	if err != nil {
+		return nil, format, fmt.Errorf("decoding %s input: %w", format.Encoding, err)
+	}
+
+	if format.Delimiter == "" {
+		format.Delimiter = string(sniffDelimiter(text))
+	}
+	if format.Quote == "" {
+		format.Quote = `"`
+	}
+	if _, err := formatRune(format.Delimiter, "delimiter"); err != nil {
+		return nil, format, err
+	}
+	quote, err := quoteRune(format.Quote)
+	if err != nil {
+		return nil, format, err
+	}
+	if quote != '"' {
+		text = []byte(swapQuotes(string(text), quote))
+	}
+
+	return text, format, nil
+}
+
+func sniffCompression(raw []byte) string {
+	switch {
+	case bytes.HasPrefix(raw, gzipMagic):
+		return "gzip"
+	case bytes.HasPrefix(raw, zstdMagic):
+		return "zstd"
+	default:
+		return "none"
+	}
+}
+
+func decompress(raw []byte, compression string) ([]byte, error) {
+	switch strings.ToLower(compression) {
+	case "none":
+		return raw, nil
+	case "gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return io.ReadAll(gz)
+	case "zstd":
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		return dec.DecodeAll(raw, nil)
+	default:
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+}
+
+// sniffEncoding goes by the byte order mark, then by whether the bytes are
+// valid UTF-8. Anything else is taken to be Latin-1.
+func sniffEncoding(raw []byte) string {
+	switch {
+	case bytes.HasPrefix(raw, utf8BOM):
+		return "utf-8"
+	case bytes.HasPrefix(raw, utf16LE):
+		return "utf-16le"
+	case bytes.HasPrefix(raw, utf16BE):
+		return "utf-16be"
+	case utf8.Valid(raw):
+		return "utf-8"
+	default:
+		return "latin-1"
+	}
+}
+
+func textEncoding(name string) (encoding.Encoding, error) {
+	switch strings.ToLower(strings.ReplaceAll(name, "_", "-")) {
+	case "utf-8", "utf8":
+		return unicode.UTF8, nil
+	case "utf-16", "utf-16le":
+		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM), nil
+	case "utf-16be":
+		return unicode.UTF16(unicode.BigEndian, unicode.UseBOM), nil
+	case "latin-1", "latin1", "iso-8859-1":
+		return charmap.ISO8859_1, nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", name)
+	}
+}
+
+// sniffDelimiter picks the candidate that occurs most often in the header
+// line, preferring earlier candidates on a tie.
+func sniffDelimiter(text []byte) rune {
+	header := text
+	if i := bytes.IndexByte(text, '\n'); i >= 0 {
+		header = text[:i]
+	}
+
+	best, bestCount := ',', 0
+	for _, delimiter := range sniffDelimiters {
+		if count := bytes.Count(header, []byte(string(delimiter))); count > bestCount {
+			best, bestCount = delimiter, count
+		}
+	}
+	return best
+}
+
+func formatRune(value, name string) (rune, error) {
+	if value == `\t` {
+		return '\t', nil
+	}
+	if utf8.RuneCountInString(value) != 1 {
+		return 0, fmt.Errorf("%s must be a single character, got %q", name, value)
+	}
+	r, _ := utf8.DecodeRuneInString(value)
+	return r, nil
+}
+
+// quoteRune is formatRune restricted to ASCII, so swapping quotes never has
+// to deal with a character split across writes.
+func quoteRune(value string) (rune, error) {
+	quote, err := formatRune(value, "quote")
+	if err == nil && quote >= utf8.RuneSelf {
+		err = fmt.Errorf("quote must be an ASCII character, got %q", value)
+	}
+	return quote, err
+}
+
+// swapQuotes exchanges quote and '"'. encoding/csv only understands '"', so
+// text using another quote character is swapped before parsing and every
+// field is swapped back afterwards; applying it twice is a no-op.
+func swapQuotes(text string, quote rune) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case quote:
+			return '"'
+		case '"':
+			return quote
+		}
+		return r
+	}, text)
+}
+
+type outputStream struct {
+	io.Writer
+	closers []io.Closer
+}
+
+func (o *outputStream) Close() error {
+	var firstErr error
+	for i := len(o.closers) - 1; i >= 0; i-- {
+		if err := o.closers[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// newOutputStream wraps w so that what is written to it comes out compressed
+// and, when textual is set, encoded and quoted like format. Close flushes every
+// layer but does not close w.
+func newOutputStream(w io.Writer, format FileFormat, textual bool) (io.WriteCloser, error) {
+	out := &outputStream{Writer: w}
+
+	switch strings.ToLower(format.Compression) {
+	case "", "none":
+	case "gzip":
+		gz := gzip.NewWriter(out.Writer)
+		out.Writer, out.closers = gz, append(out.closers, gz)
+	case "zstd":
+		enc, err := zstd.NewWriter(out.Writer)
+		if err != nil {
+			return nil, err
+		}
+		out.Writer, out.closers = enc, append(out.closers, enc)
+	default:
+		return nil, fmt.Errorf("unknown compression %q", format.Compression)
+	}
+
+	if !textual {
+		return out, nil
+	}
+
+	if format.Encoding != "" {
+		enc, err := textEncoding(format.Encoding)
+		if err != nil {
+			return nil, err
+		}
+		if enc != unicode.UTF8 {
+			tw := transform.NewWriter(out.Writer, encoding.ReplaceUnsupported(enc.NewEncoder()))
+			out.Writer, out.closers = tw, append(out.closers, tw)
+		}
+	}
+
+	if format.Quote != "" && format.Quote != `"` {
+		quote, err := quoteRune(format.Quote)
+		if err != nil {
+			return nil, err
+		}
+		out.Writer = &quoteSwapWriter{w: out.Writer, quote: quote}
+	}
+
+	return out, nil
+}
+
+type quoteSwapWriter struct {
+	w     io.Writer
+	quote rune
+}
+
+func (q *quoteSwapWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(q.w, swapQuotes(string(p), q.quote)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
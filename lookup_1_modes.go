@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/search"
+)
+
+const (
+	modeExact           = "exact"
+	modePrefix          = "prefix"
+	modeContains        = "contains"
+	modeReverseContains = "reverse-contains"
+	modeFuzzy           = "fuzzy"
+	modePhonetic        = "phonetic"
+
+	fuzzyMaxDistance = 2
+)
+
+// defaultModes prefers an exact hit, then falls back to the original order:
+// the search string containing a lookup value wins over a lookup value
+// containing the search string.
+var defaultModes = []string{modeExact, modeContains, modeReverseContains}
+
+// matchTypes is what each mode reports as match_type. contains and
+// reverse-contains keep the names they had before modes were selectable.
+var matchTypes = map[string]string{
+	modeExact:           "exact",
+	modePrefix:          "prefix",
+	modeContains:        "contains_lookup",
+	modeReverseContains: "lookup_contains",
+	modeFuzzy:           "fuzzy",
+	modePhonetic:        "phonetic",
+}
+
+// parseModes validates an ordered mode list. An empty list means
+// defaultModes; duplicates are dropped, keeping the first occurrence.
+func parseModes(modes []string) ([]string, error) {
+	if len(modes) == 0 {
+		return defaultModes, nil
+	}
+
+	parsed := make([]string, 0, len(modes))
+	seen := make(map[string]bool)
+	for _, mode := range modes {
+		mode = strings.ToLower(strings.TrimSpace(mode))
+		if mode == "" || seen[mode] {
+			continue
+		}
+		if _, ok := matchTypes[mode]; !ok {
+			return nil, fmt.Errorf("unknown match mode %q", mode)
+		}
+		seen[mode] = true
+		parsed = append(parsed, mode)
+	}
+	if len(parsed) == 0 {
+		return defaultModes, nil
+	}
+	return parsed, nil
+}
+
+func modesKey(modes []string) string {
+	return strings.Join(modes, ",")
+}
+
+// matchMode reports whether lookupValue matches searchValue under mode. Both
+// values are already normalized. Exact matches never get here; performLookup
+// resolves them with a point lookup.
+func (s *Server) matchMode(mode, searchValue, lookupValue string) bool {
+	switch mode {
+	case modePrefix:
+		start, _ := s.matcher.IndexString(lookupValue, searchValue, search.Anchor)
+		return start == 0
+	case modeContains:
+		start, _ := s.matcher.IndexString(searchValue, lookupValue)
+		return start != -1
+	case modeReverseContains:
+		start, _ := s.matcher.IndexString(lookupValue, searchValue)
+		return start != -1
+	case modeFuzzy:
+		return levenshtein(searchValue, lookupValue) <= fuzzyMaxDistance
+	case modePhonetic:
+		return phoneticKey(searchValue) != "" && phoneticKey(searchValue) == phoneticKey(lookupValue)
+	}
+	return false
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(min(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// phoneticKey is the Soundex code of every word in value, space separated.
+func phoneticKey(value string) string {
+	words := strings.Fields(value)
+	codes := make([]string, 0, len(words))
+	for _, word := range words {
+		if code := soundex(word); code != "" {
+			codes = append(codes, code)
+		}
+	}
+	return strings.Join(codes, " ")
+}
+
+var soundexDigits = map[rune]byte{
+	'b': '1', 'f': '1', 'p': '1', 'v': '1',
+	'c': '2', 'g': '2', 'j': '2', 'k': '2', 'q': '2', 's': '2', 'x': '2', 'z': '2',
+	'd': '3', 't': '3',
+	'l': '4',
+	'm': '5', 'n': '5',
+	'r': '6',
+}
+
+func soundex(word string) string {
+	var code []byte
+	var last byte
+	for _, r := range strings.ToLower(word) {
+		if !unicode.IsLetter(r) || r > unicode.MaxASCII {
+			continue
+		}
+		digit := soundexDigits[r]
+		if code == nil {
+			code = append(code, byte(unicode.ToUpper(r)))
+			last = digit
+			continue
+		}
+		if digit != 0 && digit != last {
+			code = append(code, digit)
+			if len(code) == 4 {
+				break
+			}
+		}
+		// h and w do not separate letters with the same code; vowels do.
+		if r != 'h' && r != 'w' {
+			last = digit
+		}
+	}
+	if code == nil {
+		return ""
+	}
+	for len(code) < 4 {
+		code = append(code, '0')
+	}
+	return string(code)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	outputCSV      = "csv"
+	outputExcelCSV = "excel-csv"
+	outputJSONL    = "jsonl"
+	outputParquet  = "parquet"
+)
+
+var recordColumns = []string{"name", "lookup_result", "matched_value", "match_type"}
+
+// RecordWriter writes processed records in one output format. Payload
+// columns are fixed when the writer is created.
+type RecordWriter interface {
+	Write(record Record) error
+	Close() error
+}
+
+func parseOutputFormat(format string) (string, error) {
+	switch format = strings.ToLower(strings.TrimSpace(format)); format {
+	case "":
+		return outputCSV, nil
+	case outputCSV, outputExcelCSV, outputJSONL, outputParquet:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// compressionExtensions maps the compressions newOutputStream applies to the
+// file extension they add.
+var compressionExtensions = map[string]string{
+	"gzip": ".gz",
+	"zstd": ".zst",
+}
+
+// outputPath is where processInputFile writes its results. CSV output still
+// replaces the input file, in the input's own format; other formats are
+// written next to it with their own extension, followed by that of
+// compression when the output is compressed.
+func outputPath(inputFile, format, compression string) string {
+	switch format {
+	case outputJSONL, outputParquet:
+		base := trimExtensions(inputFile) + "." + format
+		output, _ := outputFileFormat(format, FileFormat{Compression: compression})
+		return base + compressionExtensions[strings.ToLower(output.Compression)]
+	default:
+		return inputFile
+	}
+}
+
+// trimExtensions strips path's compression extension, if it has one, and
+// then its data extension: names.csv.gz becomes names.
+func trimExtensions(path string) string {
+	for _, ext := range compressionExtensions {
+		if strings.EqualFold(filepath.Ext(path), ext) {
+			path = strings.TrimSuffix(path, filepath.Ext(path))
+			break
+		}
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
+// newRecordWriter creates a writer for format. delimiter only applies to
+// plain CSV output.
+func newRecordWriter(format string, w io.Writer, delimiter rune, payloadFields []string) (RecordWriter, error) {
+	switch format {
+	case outputCSV:
+		return newCSVRecordWriter(w, delimiter, false, payloadFields)
+	case outputExcelCSV:
+		return newCSVRecordWriter(w, ';', true, payloadFields)
+	case outputJSONL:
+		return &jsonlRecordWriter{encoder: json.NewEncoder(w)}, nil
+	case outputParquet:
+		return newParquetRecordWriter(w, payloadFields), nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+type csvRecordWriter struct {
+	writer        *csv.Writer
+	payloadFields []string
+}
+
+// newCSVRecordWriter writes the header straight away. Excel mode adds a UTF-8
+// byte order mark and CRLF line endings so Excel opens the file with the
+// right encoding and delimiter.
+func newCSVRecordWriter(w io.Writer, comma rune, excel bool, payloadFields []string) (*csvRecordWriter, error) {
+	if excel {
+		if _, err := io.WriteString(w, "\xEF\xBB\xBF"); err != nil {
+			return nil, err
+		}
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Comma = comma
+	writer.UseCRLF = excel
+
+	header := append(append([]string{}, recordColumns...), payloadFields...)
+	if err := writer.Write(header); err != nil {
+		return nil, err
+	}
+	return &csvRecordWriter{writer: writer, payloadFields: payloadFields}, nil
+}
+
+func (cw *csvRecordWriter) Write(record Record) error {
+	row := []string{
+		record.Name,
+		strconv.FormatBool(record.Result),
+		record.MatchedValue,
+		record.MatchType,
+	}
+	for _, field := range cw.payloadFields {
+		row = append(row, record.Payload[field])
+	}
+	return cw.writer.Write(row)
+}
+
+func (cw *csvRecordWriter) Close() error {
+	cw.writer.Flush()
+	return cw.writer.Error()
+}
+
+type jsonlRecord struct {
+	Name         string            `json:"name"`
+	LookupResult bool              `json:"lookup_result"`
+	MatchedValue string            `json:"matched_value,omitempty"`
+	MatchType    string            `json:"match_type,omitempty"`
+	Payload      map[string]string `json:"payload,omitempty"`
+}
+
+type jsonlRecordWriter struct {
+	encoder *json.Encoder
+}
+
+func (jw *jsonlRecordWriter) Write(record Record) error {
+	return jw.encoder.Encode(jsonlRecord{
+		Name:         record.Name,
+		LookupResult: record.Result,
+		MatchedValue: record.MatchedValue,
+		MatchType:    record.MatchType,
+		Payload:      record.Payload,
+	})
+}
+
+func (jw *jsonlRecordWriter) Close() error {
+	return nil
+}
+
+// parquetRecordWriter stores lookup_result as a boolean column and every
+// payload field as its own optional string column.
+type parquetRecordWriter struct {
+	writer        *parquet.Writer
+	payloadFields []string
+}
+
+func newParquetRecordWriter(w io.Writer, payloadFields []string) *parquetRecordWriter {
+	group := parquet.Group{
+		"name":          parquet.String(),
+		"lookup_result": parquet.Leaf(parquet.BooleanType),
+		"matched_value": parquet.Optional(parquet.String()),
+		"match_type":    parquet.Optional(parquet.String()),
+	}
+	for _, field := range payloadFields {
+		if _, taken := group[field]; !taken {
+			group[field] = parquet.Optional(parquet.String())
+		}
+	}
+
+	schema := parquet.NewSchema("record", group)
+	return &parquetRecordWriter{
+		writer:        parquet.NewWriter(w, schema),
+		payloadFields: payloadFields,
+	}
+}
+
+func (pw *parquetRecordWriter) Write(record Record) error {
+	row := map[string]interface{}{
+		"name":          record.Name,
+		"lookup_result": record.Result,
+	}
+	if record.MatchedValue != "" {
+		row["matched_value"] = record.MatchedValue
+	}
+	if record.MatchType != "" {
+		row["match_type"] = record.MatchType
+	}
+	for _, field := range pw.payloadFields {
+		if value, ok := record.Payload[field]; ok {
+			if _, taken := row[field]; !taken {
+				row[field] = value
+			}
+		}
+	}
+	return pw.writer.Write(row)
+}
+
+func (pw *parquetRecordWriter) Close() error {
+	return pw.writer.Close()
+}
+
+// outputFileFormat adapts the resolved input format to an output format.
+// Excel CSV is always UTF-8 with standard quoting, JSON Lines is always UTF-8,
+// and Parquet compresses internally, so only plain CSV keeps everything.
+func outputFileFormat(format string, input FileFormat) (FileFormat, bool) {
+	switch format {
+	case outputCSV:
+		return input, true
+	case outputExcelCSV:
+		return FileFormat{Compression: input.Compression}, true
+	case outputJSONL:
+		return FileFormat{Compression: input.Compression}, false
+	default:
+		return FileFormat{}, false
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestOutputPath(t *testing.T) {
+	tests := []struct {
+		input, format, compression, want string
+	}{
+		{"names.csv", outputCSV, "none", "names.csv"},
+		{"names.csv.gz", outputCSV, "gzip", "names.csv.gz"},
+		{"names.csv", outputJSONL, "none", "names.jsonl"},
+		{"names.csv", outputJSONL, "", "names.jsonl"},
+		{"names.csv.gz", outputJSONL, "gzip", "names.jsonl.gz"},
+		{"names.csv.zst", outputJSONL, "zstd", "names.jsonl.zst"},
+		{"names.CSV.GZ", outputJSONL, "gzip", "names.jsonl.gz"},
+		{"names.csv", outputJSONL, "gzip", "names.jsonl.gz"},
+		{"names.csv.gz", outputParquet, "gzip", "names.parquet"},
+	}
+	for _, tt := range tests {
+		if got := outputPath(tt.input, tt.format, tt.compression); got != tt.want {
+			t.Errorf("outputPath(%q, %q, %q) = %q, want %q", tt.input, tt.format, tt.compression, got, tt.want)
+		}
+	}
+}
+
+func TestRejectsPath(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{"names.csv", "names.rejects.csv"},
+		{"names.csv.gz", "names.rejects.csv"},
+		{"names.tsv.zst", "names.rejects.csv"},
+		{"dir/names.CSV.GZ", "dir/names.rejects.csv"},
+		{"names", "names.rejects.csv"},
+	}
+	for _, tt := range tests {
+		if got := rejectsPath(tt.input); got != tt.want {
+			t.Errorf("rejectsPath(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
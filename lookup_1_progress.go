@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+const (
+	progressAuto     = "auto"
+	progressTerminal = "terminal"
+	progressLog      = "log"
+	progressNone     = "none"
+
+	progressLogInterval = time.Second
+	finishedJobsKept    = 50
+)
+
+// ProgressReporter is told about long-running work such as loading a lookup
+// file or processing an input file. task names the kind of work and subject
+// what it is working on; total is -1 when the amount of work is not known up
+// front. ctx identifies the request the work is done for, if any.
+type ProgressReporter interface {
+	Start(ctx context.Context, task, subject string, total int64) Progress
+}
+
+// Progress tracks one piece of work started on a ProgressReporter. Add may be
+// called from several goroutines at once.
+type Progress interface {
+	Add(n int)
+	Finish()
+}
+
+// newProgressReporter returns the reporter for a -progress setting. auto
+// draws terminal bars for one-off runs and logs in server mode, where bars
+// would end up interleaved with the log.
+func newProgressReporter(kind string, serverMode bool, logger *slog.Logger) (ProgressReporter, error) {
+	if kind == "" || kind == progressAuto {
+		kind = progressTerminal
+		if serverMode {
+			kind = progressLog
+		}
+	}
+	switch kind {
+	case progressTerminal:
+		return terminalReporter{}, nil
+	case progressLog:
+		return logReporter{logger: logger}, nil
+	case progressNone:
+		return noopReporter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown progress reporter %q", kind)
+	}
+}
+
+type terminalReporter struct{}
+
+func (terminalReporter) Start(ctx context.Context, task, subject string, total int64) Progress {
+	return terminalProgress{progressbar.Default(total, task)}
+}
+
+type terminalProgress struct {
+	bar *progressbar.ProgressBar
+}
+
+func (p terminalProgress) Add(n int) { p.bar.Add(n) }
+func (p terminalProgress) Finish()   { p.bar.Finish() }
+
+type noopReporter struct{}
+
+func (noopReporter) Start(ctx context.Context, task, subject string, total int64) Progress {
+	return noopProgress{}
+}
+
+type noopProgress struct{}
+
+func (noopProgress) Add(int) {}
+func (noopProgress) Finish() {}
+
+// logReporter logs when work starts, at most every progressLogInterval while
+// it runs, and when it finishes.
+type logReporter struct {
+	logger *slog.Logger
+}
+
+func (r logReporter) Start(ctx context.Context, task, subject string, total int64) Progress {
+	p := &logProgress{
+		ctx:     ctx,
+		logger:  r.logger.With("task", task, "subject", subject, "total", total),
+		started: time.Now(),
+	}
+	p.lastLog.Store(p.started.UnixNano())
+	p.log("progress started")
+	return p
+}
+
+type logProgress struct {
+	ctx     context.Context
+	logger  *slog.Logger
+	started time.Time
+	done    atomic.Int64
+	lastLog atomic.Int64
+}
+
+func (p *logProgress) Add(n int) {
+	p.done.Add(int64(n))
+
+	now := time.Now().UnixNano()
+	last := p.lastLog.Load()
+	if now-last >= int64(progressLogInterval) && p.lastLog.CompareAndSwap(last, now) {
+		p.log("progress")
+	}
+}
+
+func (p *logProgress) Finish() {
+	p.log("progress finished")
+}
+
+func (p *logProgress) log(msg string) {
+	p.logger.InfoContext(p.ctx, msg, "done", p.done.Load(), "elapsed", time.Since(p.started))
+}
+
+// JobStatus is how /jobs reports one piece of work.
+type JobStatus struct {
+	ID         int64      `json:"id"`
+	RequestID  string     `json:"request_id,omitempty"`
+	Task       string     `json:"task"`
+	Subject    string     `json:"subject,omitempty"`
+	Done       int64      `json:"done"`
+	Total      int64      `json:"total"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// jobTracker keeps the status of running work, and of the most recently
+// finished, for the /jobs endpoint. It wraps another reporter so progress is
+// still drawn or logged as well.
+type jobTracker struct {
+	next ProgressReporter
+
+	mu       sync.Mutex
+	lastID   int64
+	running  map[int64]*trackedProgress
+	finished []JobStatus
+}
+
+func newJobTracker(next ProgressReporter) *jobTracker {
+	return &jobTracker{next: next, running: make(map[int64]*trackedProgress)}
+}
+
+func (t *jobTracker) Start(ctx context.Context, task, subject string, total int64) Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastID++
+	p := &trackedProgress{
+		tracker:   t,
+		next:      t.next.Start(ctx, task, subject, total),
+		id:        t.lastID,
+		requestID: requestIDFrom(ctx),
+		task:      task,
+		subject:   subject,
+		total:     total,
+		startedAt: time.Now(),
+	}
+	t.running[p.id] = p
+	return p
+}
+
+// jobs lists running work first, then finished work, newest first.
+func (t *jobTracker) jobs() []JobStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	jobs := make([]JobStatus, 0, len(t.running)+len(t.finished))
+	for _, p := range t.running {
+		jobs = append(jobs, p.status())
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID > jobs[j].ID })
+	for i := len(t.finished) - 1; i >= 0; i-- {
+		jobs = append(jobs, t.finished[i])
+	}
+	return jobs
+}
+
+func (t *jobTracker) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t.jobs())
+}
+
+type trackedProgress struct {
+	tracker   *jobTracker
+	next      Progress
+	id        int64
+	requestID string
+	task      string
+	subject   string
+	total     int64
+	startedAt time.Time
+	done      atomic.Int64
+	finished  sync.Once
+}
+
+func (p *trackedProgress) status() JobStatus {
+	return JobStatus{
+		ID:        p.id,
+		RequestID: p.requestID,
+		Task:      p.task,
+		Subject:   p.subject,
+		Done:      p.done.Load(),
+		Total:     p.total,
+		StartedAt: p.startedAt,
+	}
+}
+
+func (p *trackedProgress) Add(n int) {
+	p.done.Add(int64(n))
+	p.next.Add(n)
+}
+
+func (p *trackedProgress) Finish() {
+	p.finished.Do(func() {
+		p.next.Finish()
+
+		t := p.tracker
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		status := p.status()
+		finishedAt := time.Now()
+		status.FinishedAt = &finishedAt
+		delete(t.running, p.id)
+		t.finished = append(t.finished, status)
+		if len(t.finished) > finishedJobsKept {
+			t.finished = t.finished[len(t.finished)-finishedJobsKept:]
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/jszwec/csvutil"
+)
+
+// rejectsPath is the sidecar that lenient runs write malformed rows to. It
+// is plain CSV whether or not the input is compressed.
+func rejectsPath(inputFile string) string {
+	return trimExtensions(inputFile) + ".rejects.csv"
+}
+
+// rejectWriter records malformed input rows with their line number and the
+// reason they were skipped. The sidecar is only created once there is
+// something to put in it.
+type rejectWriter struct {
+	path   string
+	quote  rune
+	header []string
+	file   *os.File
+	writer *csv.Writer
+	count  int
+}
+
+func newRejectWriter(inputFile string, quote rune) (*rejectWriter, error) {
+	rw := &rejectWriter{path: rejectsPath(inputFile), quote: quote}
+	if err := os.Remove(rw.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return rw, nil
+}
+
+func (rw *rejectWriter) reject(line int, reason error, fields []string) error {
+	if rw.file == nil {
+		file, err := os.Create(rw.path)
+		if err != nil {
+			return err
+		}
+		rw.file = file
+		rw.writer = csv.NewWriter(file)
+		if err := rw.writer.Write(append([]string{"line", "reason"}, rw.header...)); err != nil {
+			return err
+		}
+	}
+
+	row := []string{strconv.Itoa(line), reason.Error()}
+	for _, field := range fields {
+		if rw.quote != '"' {
+			field = swapQuotes(field, rw.quote)
+		}
+		row = append(row, field)
+	}
+	rw.count++
+	return rw.writer.Write(row)
+}
+
+// Close flushes the sidecar. It is safe to call more than once.
+func (rw *rejectWriter) Close() error {
+	if rw.file == nil {
+		return nil
+	}
+	file := rw.file
+	rw.file = nil
+
+	rw.writer.Flush()
+	if err := rw.writer.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// decodeInputRecords decodes every row of reader into a Record. Without a
+// rejectWriter the first malformed row fails the whole file; with one, the
+// row is written to the sidecar instead, until more than maxErrors rows have
+// been rejected (0 means no limit).
+func decodeInputRecords(reader *csv.Reader, rejects *rejectWriter, maxErrors int) ([]Record, error) {
+	decoder, err := csvutil.NewDecoder(reader)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rejects != nil {
+		rejects.header = decoder.Header()
+	}
+
+	var records []Record
+	for {
+		var record Record
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err == nil {
+			records = append(records, record)
+			continue
+		}
+		if rejects == nil {
+			return nil, err
+		}
+
+		var line int
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			line, err = parseErr.StartLine, parseErr.Err
+		} else {
+			line, _ = reader.FieldPos(0)
+		}
+		if err := rejects.reject(line, err, decoder.Record()); err != nil {
+			return nil, err
+		}
+		if maxErrors > 0 && rejects.count > maxErrors {
+			return nil, fmt.Errorf("aborting after %d malformed rows (max %d), see %s", rejects.count, maxErrors, rejects.path)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var errReloadInProgress = errors.New("reload already in progress")
+
+type ReloadRequest struct {
+	LookupFile   string `json:"lookup_file"`
+	SnapshotPath string `json:"snapshot_path"`
+}
+
+type VersionResponse struct {
+	DictionaryMeta
+	LoadedAt        time.Time `json:"loaded_at"`
+	LastReloadError string    `json:"last_reload_error,omitempty"`
+	// Healthy is false once a failed reload left no store to serve from;
+	// lookups then fail until a reload succeeds.
+	Healthy bool `json:"healthy"`
+}
+
+// reload builds a new store from lookupFile or snapshotPath next to the live
+// one and swaps it in. Lookups keep being served from the old store while the
+// new one is built; they only wait for the swap itself.
+func (s *Server) reload(ctx context.Context, lookupFile, snapshotPath string) error {
+	if !s.reloadMu.TryLock() {
+		return errReloadInProgress
+	}
+	defer s.reloadMu.Unlock()
+
+	return s.runReload(ctx, lookupFile, snapshotPath)
+}
+
+// runReload does the work of reload; the caller must hold reloadMu. It logs
+// the outcome under ctx.
+func (s *Server) runReload(ctx context.Context, lookupFile, snapshotPath string) error {
+	slog.InfoContext(ctx, "reloading lookup dictionary", "lookup_file", lookupFile, "snapshot", snapshotPath)
+	err := s.buildAndSwap(ctx, lookupFile, snapshotPath)
+	if err != nil {
+		s.reloadErr.Store(err.Error())
+		slog.ErrorContext(ctx, "reload failed", "error", err)
+	} else {
+		s.reloadErr.Store("")
+		slog.InfoContext(ctx, "reload complete", "dictionary_version", s.version().Version)
+	}
+	return err
+}
+
+func (s *Server) buildAndSwap(ctx context.Context, lookupFile, snapshotPath string) error {
+	staging := s.config.DBPath + ".reload"
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	db, err := openBackend(s.config.Backend, staging)
+	if err != nil {
+		return err
+	}
+
+	var meta DictionaryMeta
+	if snapshotPath != "" {
+		lookupFile = ""
+		var header *SnapshotHeader
+		if header, err = loadSnapshotInto(snapshotPath, db); err == nil {
+			meta = header.Meta
+		}
+	} else {
+		meta, err = buildLookupDB(ctx, db, lookupFile, s.config.LookupFormat, s.config.LookupKey, s.config.Progress)
+	}
+	var index *lookupIndex
+	if err == nil && s.config.MemoryIndex {
+		index, err = buildLookupIndex(db)
+	}
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	return s.swapDB(db, index, staging, meta, lookupFile, snapshotPath)
+}
+
+// swapDB replaces the live store with db, which was built at staging, and its
+// index, then clears matchCache. On-disk stores are renamed into config.DBPath so the
+// location stays stable; the old store is moved aside first so it can be put
+// back if the new one fails to open. The sources are remembered so the next
+// SIGHUP reloads from them.
+func (s *Server) swapDB(db Backend, index *lookupIndex, staging string, meta DictionaryMeta, lookupFile, snapshotPath string) error {
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+
+	if _, inMemory := db.(*memoryBackend); !inMemory {
+		if err := db.Close(); err != nil {
+			return err
+		}
+		var err error
+		if db, err = s.replaceOnDisk(staging); err != nil {
+			return err
+		}
+	} else {
+		s.db.Close()
+	}
+
+	s.db = db
+	s.index = index
+	s.meta = meta
+	s.loadedAt = time.Now()
+	s.lookupFile = lookupFile
+	s.config.SnapshotPath = snapshotPath
+	s.matchCache.Range(func(key, _ interface{}) bool {
+		s.matchCache.Delete(key)
+		return true
+	})
+	return nil
+}
+
+// replaceOnDisk closes the live store, moves staging into its place and opens
+// it. If any step fails the previous store is put back and reopened; should
+// that fail too, the server is left on an unavailableBackend rather than a
+// closed one. The caller must hold dbMu for writing.
+func (s *Server) replaceOnDisk(staging string) (Backend, error) {
+	dbPath := s.config.DBPath
+	oldPath := dbPath + ".old"
+	if err := os.RemoveAll(oldPath); err != nil {
+		return nil, err
+	}
+
+	reopen := func(cause error) (Backend, error) {
+		db, err := openBackend(s.config.Backend, dbPath)
+		if err != nil {
+			slog.Error("reload failed and previous store could not be reopened", "path", dbPath, "error", err)
+			db = unavailableBackend{cause: err}
+		}
+		s.db = db
+		return nil, cause
+	}
+
+	if err := s.db.Close(); err != nil {
+		return reopen(err)
+	}
+
+	// A store that failed to reopen after an earlier reload may be missing;
+	// there is then nothing to move aside or put back.
+	moved := true
+	if err := os.Rename(dbPath, oldPath); errors.Is(err, os.ErrNotExist) {
+		moved = false
+	} else if err != nil {
+		return reopen(err)
+	}
+
+	restore := func(cause error) (Backend, error) {
+		if !moved {
+			s.db = unavailableBackend{cause: cause}
+			return nil, cause
+		}
+		if err := os.RemoveAll(dbPath); err != nil {
+			slog.Error("reload failed and new store could not be removed", "path", dbPath, "error", err)
+			s.db = unavailableBackend{cause: err}
+			return nil, cause
+		}
+		if err := os.Rename(oldPath, dbPath); err != nil {
+			slog.Error("reload failed and previous store could not be put back", "path", oldPath, "error", err)
+			s.db = unavailableBackend{cause: err}
+			return nil, cause
+		}
+		return reopen(cause)
+	}
+
+	if err := os.Rename(staging, dbPath); err != nil {
+		return restore(err)
+	}
+	db, err := openBackend(s.config.Backend, dbPath)
+	if err != nil {
+		return restore(err)
+	}
+
+	if err := os.RemoveAll(oldPath); err != nil {
+		slog.Warn("could not remove previous store", "path", oldPath, "error", err)
+	}
+	return db, nil
+}
+
+func (s *Server) reloadOnSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	for range sigs {
+		s.dbMu.RLock()
+		lookupFile, snapshotPath := s.lookupFile, s.config.SnapshotPath
+		s.dbMu.RUnlock()
+
+		slog.Info("SIGHUP received")
+		if err := s.reload(context.Background(), lookupFile, snapshotPath); errors.Is(err, errReloadInProgress) {
+			slog.Warn("reload skipped", "error", err)
+		}
+	}
+}
+
+func (s *Server) version() VersionResponse {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+
+	response := VersionResponse{
+		DictionaryMeta: s.meta,
+		LoadedAt:       s.loadedAt,
+	}
+	_, unavailable := s.db.(unavailableBackend)
+	response.Healthy = !unavailable
+	if errMsg, ok := s.reloadErr.Load().(string); ok {
+		response.LastReloadError = errMsg
+	}
+	return response
+}
+
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.version())
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ReloadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.LookupFile == "" && req.SnapshotPath == "" {
+		s.dbMu.RLock()
+		req.LookupFile, req.SnapshotPath = s.lookupFile, s.config.SnapshotPath
+		s.dbMu.RUnlock()
+	}
+	if req.LookupFile == "" && req.SnapshotPath == "" {
+		httpError(w, r, "lookup_file or snapshot_path is required", http.StatusBadRequest)
+		return
+	}
+
+	if !s.reloadMu.TryLock() {
+		httpError(w, r, errReloadInProgress.Error(), http.StatusConflict)
+		return
+	}
+
+	// The reload outlives the request but keeps its request ID for logging.
+	ctx := context.WithoutCancel(r.Context())
+	go func() {
+		defer s.reloadMu.Unlock()
+		s.runReload(ctx, req.LookupFile, req.SnapshotPath)
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
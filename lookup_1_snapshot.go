@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// Snapshot layout (everything after the magic is gzip compressed):
+//
+//	magic "LKSNAP1\n"
+//	uvarint header length, header JSON
+//	repeated: uvarint key length, key, uvarint value length, value
+//	uvarint 0 (end of entries)
+//	sha256 of all uncompressed bytes above
+const (
+	snapshotMagic  = "LKSNAP1\n"
+	snapshotFormat = 1
+
+	// maxSnapshotField bounds a single length-prefixed header, key or
+	// value, so a corrupt length cannot make the reader allocate before the
+	// checksum has been verified.
+	maxSnapshotField = 64 << 20
+)
+
+type SnapshotHeader struct {
+	Format int            `json:"format"`
+	Meta   DictionaryMeta `json:"meta"`
+}
+
+type snapshotWriter struct {
+	w   io.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (sw *snapshotWriter) writeBytes(b []byte) error {
+	n := binary.PutUvarint(sw.buf[:], uint64(len(b)))
+	if _, err := sw.w.Write(sw.buf[:n]); err != nil {
+		return err
+	}
+	_, err := sw.w.Write(b)
+	return err
+}
+
+// snapshotReader hashes exactly the bytes it consumes, so the trailing
+// checksum can be read from r afterwards without being hashed itself.
+type snapshotReader struct {
+	r      *bufio.Reader
+	hasher hash.Hash
+}
+
+func (sr *snapshotReader) ReadByte() (byte, error) {
+	b, err := sr.r.ReadByte()
+	if err == nil {
+		sr.hasher.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (sr *snapshotReader) readBytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(sr)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	if n > maxSnapshotField {
+		return nil, fmt.Errorf("field of %d bytes exceeds the %d byte limit", n, maxSnapshotField)
+	}
+	// Grow the buffer as bytes arrive rather than trusting n up front: a
+	// truncated stream then fails having allocated only what it held.
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, sr.r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	b := buf.Bytes()
+	sr.hasher.Write(b)
+	return b, nil
+}
+
+// exportSnapshot writes every lookup entry of db plus its dictionary metadata
+// to path. Entries are written in key order and the gzip header carries no
+// timestamp, so the same store always produces the same file. db must not be
+// written to while the export runs.
+func exportSnapshot(db Backend, path string) (*SnapshotHeader, error) {
+	meta, err := readDictionaryMeta(db)
+	if err != nil {
+		return nil, err
+	}
+	meta.Entries, err = countEntries(db)
+	if err != nil {
+		return nil, err
+	}
+	header := &SnapshotHeader{Format: snapshotFormat, Meta: meta}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpPath)
+	defer file.Close()
+
+	if _, err := file.WriteString(snapshotMagic); err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(file)
+	hasher := sha256.New()
+	sw := &snapshotWriter{w: io.MultiWriter(gz, hasher)}
+
+	if err := sw.writeBytes(headerJSON); err != nil {
+		return nil, err
+	}
+
+	var writeErr error
+	err = db.Iterate(nil, func(key, value []byte) bool {
+		if isMetaKey(key) {
+			return true
+		}
+		if writeErr = sw.writeBytes(key); writeErr != nil {
+			return false
+		}
+		writeErr = sw.writeBytes(value)
+		return writeErr == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if writeErr != nil {
+		return nil, writeErr
+	}
+
+	if err := sw.writeBytes(nil); err != nil {
+		return nil, err
+	}
+	if _, err := gz.Write(hasher.Sum(nil)); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+
+	return header, os.Rename(tmpPath, path)
+}
+
+func countEntries(db Backend) (int64, error) {
+	var n int64
+	err := db.Iterate(nil, func(key, _ []byte) bool {
+		if !isMetaKey(key) {
+			n++
+		}
+		return true
+	})
+	return n, err
+}
+
+// importSnapshot verifies the snapshot at path and loads it into a fresh
+// store of the given backend kind at dbPath. The store is built next to
+// dbPath and only renamed into place once it is complete, so dbPath never
+// holds a partial import. dbPath must not already exist.
+func importSnapshot(path, kind, dbPath string) (*SnapshotHeader, error) {
+	if _, err := os.Stat(dbPath); err == nil {
+		return nil, fmt.Errorf("import snapshot: %s already exists", dbPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	tmpPath := dbPath + ".importing"
+	if err := os.RemoveAll(tmpPath); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpPath)
+
+	db, err := openBackend(kind, tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	header, err := loadSnapshotInto(path, db)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// openSnapshot checks the magic and reads the header, leaving the returned
+// reader positioned at the first entry.
+func openSnapshot(path string) (*snapshotReader, *SnapshotHeader, func() error, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(file, magic); err != nil || string(magic) != snapshotMagic {
+		file.Close()
+		return nil, nil, nil, fmt.Errorf("%s: not a lookup snapshot", path)
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, nil, err
+	}
+	closeFn := func() error {
+		gz.Close()
+		return file.Close()
+	}
+
+	sr := &snapshotReader{r: bufio.NewReader(gz), hasher: sha256.New()}
+	headerJSON, err := sr.readBytes()
+	if err != nil {
+		closeFn()
+		return nil, nil, nil, fmt.Errorf("%s: reading header: %w", path, err)
+	}
+	var header SnapshotHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		closeFn()
+		return nil, nil, nil, fmt.Errorf("%s: reading header: %w", path, err)
+	}
+	if header.Format != snapshotFormat {
+		closeFn()
+		return nil, nil, nil, fmt.Errorf("%s: unsupported snapshot format %d", path, header.Format)
+	}
+
+	return sr, &header, closeFn, nil
+}
+
+// restoreSnapshot makes dbPath hold the contents of the snapshot at path. A
+// store already at the snapshot's version is reused as is; anything else is
+// replaced by a fresh import once that import has fully succeeded.
+func restoreSnapshot(path, kind, dbPath string) (*SnapshotHeader, error) {
+	_, header, closeFn, err := openSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	closeFn()
+
+	if _, err := os.Stat(dbPath); errors.Is(err, os.ErrNotExist) {
+		return importSnapshot(path, kind, dbPath)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if db, err := openBackend(kind, dbPath); err == nil {
+		meta, err := readDictionaryMeta(db)
+		db.Close()
+		if err == nil && meta.Version != "" && meta.Version == header.Meta.Version {
+			return header, nil
+		}
+	}
+
+	staging := dbPath + ".new"
+	oldPath := dbPath + ".old"
+	if err := os.RemoveAll(staging); err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(oldPath); err != nil {
+		return nil, err
+	}
+	header, err = importSnapshot(path, kind, staging)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(dbPath, oldPath); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(staging, dbPath); err != nil {
+		return nil, err
+	}
+	return header, os.RemoveAll(oldPath)
+}
+
+func loadSnapshotInto(path string, db Backend) (*SnapshotHeader, error) {
+	sr, header, closeFn, err := openSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+
+	batch := new(WriteBatch)
+	var entries int64
+	for {
+		key, err := sr.readBytes()
+		if err != nil {
+			return nil, fmt.Errorf("%s: reading entry %d: %w", path, entries, err)
+		}
+		if key == nil {
+			break
+		}
+		value, err := sr.readBytes()
+		if err != nil {
+			return nil, fmt.Errorf("%s: reading entry %d: %w", path, entries, err)
+		}
+		batch.Put(key, value)
+		entries++
+
+		if batch.Len() >= writeBatchSize {
+			if err := db.Write(batch); err != nil {
+				return nil, err
+			}
+			batch.Reset()
+		}
+	}
+
+	want := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(sr.r, want); err != nil {
+		return nil, fmt.Errorf("%s: missing checksum: %w", path, err)
+	}
+	if !bytes.Equal(sr.hasher.Sum(nil), want) {
+		return nil, fmt.Errorf("%s: checksum mismatch", path)
+	}
+	if entries != header.Meta.Entries {
+		return nil, fmt.Errorf("%s: expected %d entries, found %d", path, header.Meta.Entries, entries)
+	}
+
+	if err := writeDictionaryMeta(batch, header.Meta); err != nil {
+		return nil, err
+	}
+	if err := db.Write(batch); err != nil {
+		return nil, err
+	}
+
+	return header, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// lookupFormat picks the lookup file format from the configured value or,
+// failing that, from the file extension. Anything unrecognised is read as
+// the original one-value-per-line text list.
+func lookupFormat(path, format string) string {
+	if format != "" {
+		return strings.ToLower(format)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return "csv"
+	case ".jsonl", ".ndjson":
+		return "jsonl"
+	default:
+		return "text"
+	}
+}
+
+// readLookupSource calls fn for every entry in the lookup file. For csv and
+// jsonl sources keyColumn names the field holding the lookup value, matched
+// without regard to case, and every other field is handed to fn as the
+// payload. Rows without a key are skipped with a warning. It returns the
+// payload field names in sorted order.
+func readLookupSource(path, format, keyColumn string, fn func(value string, payload map[string]string) error) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if keyColumn == "" {
+		keyColumn = "name"
+	}
+
+	var fields []string
+	var skipped int
+	switch lookupFormat(path, format) {
+	case "text":
+		return nil, readTextSource(file, fn)
+	case "csv":
+		fields, skipped, err = readCSVSource(file, keyColumn, fn)
+	case "jsonl":
+		fields, skipped, err = readJSONLSource(file, keyColumn, fn)
+	default:
+		return nil, fmt.Errorf("unknown lookup format %q", format)
+	}
+	if err == nil && skipped > 0 {
+		slog.Warn("skipped lookup rows without a key", "path", path, "key", keyColumn, "rows", skipped)
+	}
+	return fields, err
+}
+
+func readTextSource(r io.Reader, fn func(string, map[string]string) error) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if err := fn(scanner.Text(), nil); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// readCSVSource reads a CSV lookup file; it also returns how many rows were
+// too short to hold the key column.
+func readCSVSource(r io.Reader, keyColumn string, fn func(string, map[string]string) error) ([]string, int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading lookup header: %w", err)
+	}
+
+	keyIndex := -1
+	var fields []string
+	for i, column := range header {
+		column = strings.TrimSpace(column)
+		header[i] = column
+		if strings.EqualFold(column, keyColumn) {
+			keyIndex = i
+		} else {
+			fields = append(fields, column)
+		}
+	}
+	if keyIndex == -1 {
+		return nil, 0, fmt.Errorf("lookup key column %q not found in header", keyColumn)
+	}
+
+	skipped := 0
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, skipped, err
+		}
+		if keyIndex >= len(row) {
+			skipped++
+			continue
+		}
+
+		var payload map[string]string
+		for i, value := range row {
+			if i == keyIndex || i >= len(header) || value == "" {
+				continue
+			}
+			if payload == nil {
+				payload = make(map[string]string)
+			}
+			payload[header[i]] = value
+		}
+		if err := fn(row[keyIndex], payload); err != nil {
+			return nil, skipped, err
+		}
+	}
+
+	sort.Strings(fields)
+	return fields, skipped, nil
+}
+
+// readJSONLSource reads a JSON lines lookup file, one object per line; it
+// also returns how many objects had no string under the key.
+func readJSONLSource(r io.Reader, keyColumn string, fn func(string, map[string]string) error) ([]string, int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 10*1024*1024)
+
+	seen := make(map[string]struct{})
+	lineNum, skipped := 0, 0
+	for scanner.Scan() {
+		lineNum++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		var object map[string]interface{}
+		if err := decoder.Decode(&object); err != nil {
+			return nil, skipped, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+
+		keyField := jsonKeyField(object, keyColumn)
+		key, ok := object[keyField].(string)
+		if !ok {
+			skipped++
+			continue
+		}
+
+		var payload map[string]string
+		for field, value := range object {
+			if field == keyField || value == nil {
+				continue
+			}
+			if payload == nil {
+				payload = make(map[string]string)
+			}
+			if str, ok := value.(string); ok {
+				payload[field] = str
+			} else {
+				encoded, _ := json.Marshal(value)
+				payload[field] = string(encoded)
+			}
+			seen[field] = struct{}{}
+		}
+		if err := fn(key, payload); err != nil {
+			return nil, skipped, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, skipped, err
+	}
+
+	fields := make([]string, 0, len(seen))
+	for field := range seen {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields, skipped, nil
+}
+
+// jsonKeyField is the field of object that holds keyColumn: the exact
+// name if present, otherwise the first to match it ignoring case, as CSV
+// headers are matched.
+func jsonKeyField(object map[string]interface{}, keyColumn string) string {
+	if _, ok := object[keyColumn]; ok {
+		return keyColumn
+	}
+	fields := make([]string, 0, len(object))
+	for field := range object {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if strings.EqualFold(field, keyColumn) {
+			return field
+		}
+	}
+	return keyColumn
+}
+
+// encodePayload turns a payload into the value stored under a lookup key.
+// Entries without a payload keep the original single-byte marker.
+func encodePayload(payload map[string]string) ([]byte, error) {
+	if len(payload) == 0 {
+		return []byte{1}, nil
+	}
+	return json.Marshal(payload)
+}
+
+func decodePayload(value []byte) map[string]string {
+	if len(value) == 0 || value[0] != '{' {
+		return nil
+	}
+	var payload map[string]string
+	if err := json.Unmarshal(value, &payload); err != nil {
+		return nil
+	}
+	return payload
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSourceKeyMatching checks that one -key finds the key field of a CSV
+// and a JSONL lookup file alike, whatever its case, and that rows without
+// the key are counted as skipped.
+func TestSourceKeyMatching(t *testing.T) {
+	csvSource := "City,Name\nParis,Alice\nOslo,Bob\nRome\n"
+	jsonlSource := `{"Name":"Alice","City":"Paris"}
+{"NAME":"Bob","City":"Oslo"}
+{"City":"Rome"}
+{"Name":42}
+`
+	tests := []struct {
+		format      string
+		read        func(string, string, func(string, map[string]string) error) ([]string, int, error)
+		source      string
+		wantSkipped int
+	}{
+		{"csv", func(src, key string, fn func(string, map[string]string) error) ([]string, int, error) {
+			return readCSVSource(strings.NewReader(src), key, fn)
+		}, csvSource, 1},
+		{"jsonl", func(src, key string, fn func(string, map[string]string) error) ([]string, int, error) {
+			return readJSONLSource(strings.NewReader(src), key, fn)
+		}, jsonlSource, 2},
+	}
+	for _, tt := range tests {
+		for _, key := range []string{"name", "Name", "NAME"} {
+			var keys []string
+			var cities []string
+			fields, skipped, err := tt.read(tt.source, key, func(value string, payload map[string]string) error {
+				keys = append(keys, value)
+				cities = append(cities, payload["City"])
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("%s -key %s: %v", tt.format, key, err)
+			}
+			if want := []string{"Alice", "Bob"}; !reflect.DeepEqual(keys, want) {
+				t.Errorf("%s -key %s: keys %q, want %q", tt.format, key, keys, want)
+			}
+			if want := []string{"Paris", "Oslo"}; !reflect.DeepEqual(cities, want) {
+				t.Errorf("%s -key %s: cities %q, want %q", tt.format, key, cities, want)
+			}
+			if !reflect.DeepEqual(fields, []string{"City"}) {
+				t.Errorf("%s -key %s: payload fields %q", tt.format, key, fields)
+			}
+			if skipped != tt.wantSkipped {
+				t.Errorf("%s -key %s: skipped %d rows, want %d", tt.format, key, skipped, tt.wantSkipped)
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// newTestServer serves lookups for values from the memory backend.
+func newTestServer(t *testing.T, config Config, values ...string) *Server {
+	t.Helper()
+	lookupFile := filepath.Join(t.TempDir(), "lookup.txt")
+	var data []byte
+	for _, value := range values {
+		data = append(data, value+"\n"...)
+	}
+	if err := os.WriteFile(lookupFile, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Backend = "memory"
+	config.Progress = noopReporter{}
+	server, err := NewServer(lookupFile, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+// TestLookupRecordsOrder checks that with many workers finishing small
+// batches out of order, every input row comes back exactly once and in
+// input order. Run it with -race.
+func TestLookupRecordsOrder(t *testing.T) {
+	var values []string
+	for i := 0; i < 500; i++ {
+		values = append(values, fmt.Sprintf("name-%05d", i*3))
+	}
+	server := newTestServer(t, Config{WorkerCount: 8, BatchSize: 7, BufferSize: 2}, values...)
+
+	const rows = 5000
+	records := make([]Record, rows)
+	for i := range records {
+		// Rows repeat so the match cache is shared between workers too.
+		records[i].Name = fmt.Sprintf("NAME-%05d", i%2000)
+	}
+
+	metrics := &Metrics{}
+	results, err := server.lookupRecords(context.Background(), records, []string{modeExact}, metrics, noopProgress{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != rows {
+		t.Fatalf("got %d results for %d rows", len(results), rows)
+	}
+
+	var matched int64
+	for i, result := range results {
+		if result.Name != records[i].Name {
+			t.Fatalf("row %d: got %q, want %q", i, result.Name, records[i].Name)
+		}
+		n := i % 2000
+		if want := n%3 == 0 && n < 1500; result.Result != want {
+			t.Errorf("row %d (%s): found %v, want %v", i, result.Name, result.Result, want)
+		}
+		if result.Result {
+			matched++
+		}
+	}
+	if metrics.ProcessedRecords != rows || metrics.MatchedRecords != matched {
+		t.Errorf("metrics: processed %d, matched %d; want %d, %d", metrics.ProcessedRecords, metrics.MatchedRecords, rows, matched)
+	}
+}
+
+// cancelProgress cancels a run once it has seen after records.
+type cancelProgress struct {
+	seen   atomic.Int64
+	after  int64
+	cancel context.CancelFunc
+}
+
+func (p *cancelProgress) Add(n int) {
+	if p.seen.Add(int64(n)) >= p.after {
+		p.cancel()
+	}
+}
+
+func (p *cancelProgress) Finish() {}
+
+// TestLookupRecordsCancel cancels a run part way through: lookupRecords must
+// return the context's error, with no partial results, once its workers
+// have stopped.
+func TestLookupRecordsCancel(t *testing.T) {
+	server := newTestServer(t, Config{WorkerCount: 4, BatchSize: 3, BufferSize: 1}, "alice", "bob")
+
+	records := make([]Record, 10000)
+	for i := range records {
+		records[i].Name = fmt.Sprintf("row-%05d", i)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bar := &cancelProgress{after: 100, cancel: cancel}
+	metrics := &Metrics{}
+	results, err := server.lookupRecords(ctx, records, []string{modeExact, modeContains}, metrics, bar)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if results != nil {
+		t.Fatalf("got %d results from a cancelled run", len(results))
+	}
+
+	// Every worker has returned, so the race detector flags this plain read
+	// if one is still counting.
+	if metrics.ProcessedRecords >= int64(len(records)) {
+		t.Fatalf("cancelled run processed all %d rows", metrics.ProcessedRecords)
+	}
+}
+
+// TestProcessInputFileStoreUnavailable checks that a store failing mid-run
+// fails the job: its rows must not be written out as not found.
+func TestProcessInputFileStoreUnavailable(t *testing.T) {
+	server := newTestServer(t, Config{WorkerCount: 4, BatchSize: 5, BufferSize: 1}, "alice", "bob")
+	server.db = unavailableBackend{cause: errors.New("reopen failed")}
+
+	dir := t.TempDir()
+	inputFile := filepath.Join(dir, "input.csv")
+	data := []byte("name\n")
+	for i := 0; i < 100; i++ {
+		data = append(data, fmt.Sprintf("name-%03d\n", i)...)
+	}
+	if err := os.WriteFile(inputFile, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, outputFile, err := server.processInputFile(context.Background(), inputFile, ProcessOptions{
+		SearchColumns: []string{"name"},
+		Modes:         []string{modeExact, modeContains},
+		OutputFormat:  "csv",
+	})
+	if !errors.Is(err, errStoreUnavailable) {
+		t.Fatalf("got %v, want errStoreUnavailable", err)
+	}
+	if outputFile != "" {
+		t.Fatalf("failed run reported output %s", outputFile)
+	}
+	// CSV output replaces the input, so a failed run must leave it as it was.
+	if after, _ := os.ReadFile(inputFile); string(after) != string(data) {
+		t.Fatalf("failed run overwrote its input with:\n%s", after)
+	}
+}